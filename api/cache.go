@@ -25,9 +25,47 @@ type Cache interface {
 		   - Load the value from a backing store (DB / External API)
 		   - Store it in cache
 		   - Return the value (cache miss)
+
+		3. If the key is expired but still within the stale-if-error grace window (if configured),
+		   and loading it fails:
+		   - Return the stale value with an error wrapping ErrStale and the load error
+
+		4. If negative caching is enabled and the backing store does not have the key:
+		   - Remember the key as missing for a short TTL
+		   - Return ErrNotFound, without asking the backing store again until the TTL ends
 	*/
 	Get(ctx context.Context, key string) (any, error)
 
+	/*
+		GetAll retrieves several keys at once.
+
+		BEHAVIOR:
+		---------
+		- Keys found in memory are returned immediately
+		- All missing keys are loaded together, in ONE round trip
+		  if the loader supports batch loading
+		- A key already being loaded by another caller is not loaded twice
+
+		The result only holds keys that exist. If some loads fail,
+		the values that could be served are returned along with the error.
+	*/
+	GetAll(ctx context.Context, keys []string) (map[string]any, error)
+
+	/*
+		GetOrLoad behaves like Get, but loads a missing key with the given function
+		instead of the cache's own loader.
+
+		BEHAVIOR:
+		---------
+		- Lets one cache hold keys that come from different sources
+		- The function may return a TTL along with the value (0 = default expiration)
+		- Concurrent misses on the same key call the function only once
+		- A refresh hook reloads the key with the same function, not with the cache's Loader
+		- On refresh, a TTL returned by the function replaces the entry's deadline;
+		  a zero TTL keeps the deadline of the first load (or the usual expiration, if it had none)
+	*/
+	GetOrLoad(ctx context.Context, key string, load func(ctx context.Context) (any, time.Duration, error)) (any, error)
+
 	/*
 		Put stores a key-value pair in the cache.
 
@@ -42,6 +80,8 @@ type Cache interface {
 		----------
 		- This version does NOT explicitly set a TTL
 		- TTL may still be applied implicitly by a global expiration strategy
+		- The write policy sees every Put, even when the expiration strategy gives it a TTL
+		- Values loaded from the backing store on a miss are NOT written back to it
 	*/
 	Put(ctx context.Context, key string, value any) error
 
@@ -52,7 +92,10 @@ type Cache interface {
 		-------------------
 		- Defines how long the key should remain valid
 		- After TTL expires, the key is considered expired
-		- Expired keys are lazily removed on access
+		- Expired keys are lazily removed on access, or by the background sweeper if one is configured
+		- The entry is a temporary, cache-only value: the write policy does NOT see it
+
+		Returns ErrEntryTooLarge if the value weighs more than a single shard can hold.
 	*/
 	PutWithTTL(ctx context.Context, key string, value any, ttl time.Duration) error
 
@@ -63,7 +106,12 @@ type Cache interface {
 		---------
 		- Removes the key from in-memory storage
 		- Removes it from eviction policy tracking
-		- Does NOT affect the backing store
+		- Deletes it from the backing store too, if the Loader implements types.Deleter
+		  and a write policy is configured:
+		  - Write-through: synchronously, before Remove returns
+		  - Write-back: as a queued tombstone, ordered with the key's pending writes.
+		    Until it is flushed, a Get finds the key missing; it never reloads it from the backing store
+		- Otherwise the backing store is NOT affected, and the next Get may load the key again
 
 		USE CASES:
 		----------
@@ -76,6 +124,13 @@ type Cache interface {
 	*/
 	Remove(key string)
 
+	/*
+		RemoveContext is Remove with a context, like Put: ctx bounds how long the write policy
+		may take to delete the key from the backing store (a slow store under write-through,
+		a full queue under write-back with OverflowBlock). The key always leaves the cache.
+	*/
+	RemoveContext(ctx context.Context, key string)
+
 	/*
 		Expire sets or updates the TTL for an existing key.
 
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+batcher implements DataLoader-style batching of Loader calls.
+
+Concurrent misses on DIFFERENT keys are collected for up to maxWait, or until
+maxBatch keys are waiting, and then loaded with a single LoadAll call.
+Each caller gets back only its own key, and only its own key's error if the batch
+load failed for some keys (types.KeyErrors). Misses on the SAME key never get here
+twice: the load group already makes them share one load.
+*/
+type batcher struct {
+	maxWait  time.Duration
+	maxBatch int
+	load     func(ctx context.Context, keys []string) (map[string]any, error)
+
+	mu      sync.Mutex
+	pending *batch
+}
+
+// batch is one group of keys that will be loaded together.
+type batch struct {
+	keys []string
+
+	// ctx is cancelled once every caller in the batch has given up.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// live is the number of callers still waiting. Guarded by batcher.mu.
+	live int
+
+	// dispatched is set once the batch has been sent. Guarded by batcher.mu.
+	dispatched bool
+
+	timer *time.Timer
+	done  chan struct{}
+	vals  map[string]any
+	err   error
+}
+
+func newBatcher(maxWait time.Duration, maxBatch int, load func(context.Context, []string) (map[string]any, error)) *batcher {
+	return &batcher{maxWait: maxWait, maxBatch: max(maxBatch, 1), load: load}
+}
+
+/*
+Load adds key to the pending batch and waits for that batch to be loaded.
+The first key of a batch starts its maxWait timer; the key that fills it sends it right away.
+*/
+func (b *batcher) Load(ctx context.Context, key string) (any, error) {
+	b.mu.Lock()
+	bt := b.pending
+	if bt == nil {
+		bt = &batch{done: make(chan struct{})}
+		bt.ctx, bt.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		bt.timer = time.AfterFunc(b.maxWait, func() { b.dispatch(bt) })
+		b.pending = bt
+	}
+	bt.keys = append(bt.keys, key)
+	bt.live++
+	full := len(bt.keys) >= b.maxBatch
+	b.mu.Unlock()
+
+	if full {
+		bt.timer.Stop()
+		b.dispatch(bt)
+	}
+
+	select {
+	case <-bt.done:
+		return bt.vals[key], keyErr(bt.err, key)
+	case <-ctx.Done():
+		b.leave(bt)
+		return nil, ctx.Err()
+	}
+}
+
+// dispatch loads a batch, unless it was already sent.
+func (b *batcher) dispatch(bt *batch) {
+	b.mu.Lock()
+	if bt.dispatched {
+		b.mu.Unlock()
+		return
+	}
+	bt.dispatched = true
+	if b.pending == bt {
+		b.pending = nil
+	}
+	b.mu.Unlock()
+
+	defer bt.cancel()
+	bt.vals, bt.err = b.load(bt.ctx, bt.keys)
+	close(bt.done)
+}
+
+// keyErr returns the error of one key of a batch load: its own if only some keys failed, err otherwise.
+func keyErr(err error, key string) error {
+	var errs types.KeyErrors
+	if errors.As(err, &errs) {
+		return errs[key]
+	}
+	return err
+}
+
+/*
+leave is called when a caller gives up. The batch load is cancelled once nobody is waiting for it.
+
+A batch abandoned before it was sent is never sent: it is detached right away,
+so callers arriving later start a fresh batch instead of joining a cancelled one.
+*/
+func (b *batcher) leave(bt *batch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bt.live--
+	if bt.live > 0 {
+		return
+	}
+	bt.cancel()
+
+	if !bt.dispatched {
+		bt.dispatched = true
+		bt.timer.Stop()
+		if b.pending == bt {
+			b.pending = nil
+		}
+	}
+}
@@ -11,10 +11,11 @@ import (
 	"github.com/krisalay/in-memory-cache/engine"
 	"github.com/krisalay/in-memory-cache/eviction"
 	"github.com/krisalay/in-memory-cache/expiration"
+	"github.com/krisalay/in-memory-cache/shard"
 	"github.com/krisalay/in-memory-cache/writepolicy"
 )
 
-func newBenchmarkCache() *cache.ShardedCache {
+func newBenchmarkCache(opts ...cache.Option) *cache.ShardedCache {
 	store := NewTestStore()
 
 	exp := &expiration.ExpireAfterAccess{TTL: 10 * time.Second}
@@ -33,6 +34,7 @@ func newBenchmarkCache() *cache.ShardedCache {
 		100000,       // capacity
 		eviction.LRU, // eviction
 		engine,
+		opts...,
 	)
 }
 
@@ -97,6 +99,16 @@ func BenchmarkCachePut(b *testing.B) {
 	}
 }
 
+func BenchmarkCachePutMutableStore(b *testing.B) {
+	ctx := context.Background()
+	c := newBenchmarkCache(cache.WithStore(shard.Mutable))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Put(ctx, fmt.Sprintf("key-%d", i), i)
+	}
+}
+
 //
 // ================= HIGH CONCURRENCY TEST =================
 //
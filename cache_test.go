@@ -2,14 +2,26 @@ package cache_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	cache "github.com/krisalay/in-memory-cache"
+	"github.com/krisalay/in-memory-cache/cachetest"
 	"github.com/krisalay/in-memory-cache/engine"
 	"github.com/krisalay/in-memory-cache/eviction"
 	"github.com/krisalay/in-memory-cache/expiration"
+	"github.com/krisalay/in-memory-cache/loader"
+	"github.com/krisalay/in-memory-cache/refresh"
+	"github.com/krisalay/in-memory-cache/shard"
+	"github.com/krisalay/in-memory-cache/types"
 	"github.com/krisalay/in-memory-cache/writepolicy"
 )
 
@@ -49,7 +61,11 @@ func (s *TestStore) Delete(key string) {
 // ================= HELPER: CREATE CACHE (WRITE-BACK MODE) =================
 //
 
-func newTestCache(capacity int) (*cache.ShardedCache, *TestStore) {
+func newTestCache(capacity int, opts ...cache.Option) (*cache.ShardedCache, *TestStore) {
+	return newTestCacheWithClock(capacity, types.SystemClock{}, opts...)
+}
+
+func newTestCacheWithClock(capacity int, clock types.Clock, opts ...cache.Option) (*cache.ShardedCache, *TestStore) {
 	store := NewTestStore()
 
 	exp := &expiration.ExpireAfterAccess{TTL: 10 * time.Second}
@@ -64,12 +80,14 @@ func newTestCache(capacity int) (*cache.ShardedCache, *TestStore) {
 		writePolicy,
 		nil,
 	)
+	engine.Clock = clock
 
 	c := cache.NewShardedCache(
 		2,            // shards
 		capacity,     // capacity
 		eviction.LRU, // eviction policy
 		engine,
+		opts...,
 	)
 
 	return c, store
@@ -131,8 +149,8 @@ func TestRemoveKey(t *testing.T) {
 
 	c.Put(ctx, "key1", "value1")
 
-	// wait briefly to allow async write-back to store
-	time.Sleep(10 * time.Millisecond)
+	// wait for the async write-back to reach the store
+	waitFor(t, "write-back of value1", func() bool { v, _ := store.Load(ctx, "key1"); return v == "value1" })
 
 	c.Remove("key1")
 
@@ -176,14 +194,15 @@ func TestEvictionOnCapacity(t *testing.T) {
 
 func TestTTLExpiration(t *testing.T) {
 	ctx := context.Background()
-	c, store := newTestCache(10)
+	clock := cachetest.NewFakeClock(time.Now())
+	c, store := newTestCacheWithClock(10, clock)
 
 	// ensure key is NOT in backing store
 	store.Delete("ttlKey")
 
 	c.PutWithTTL(ctx, "ttlKey", "temp", 1*time.Second)
 
-	time.Sleep(2 * time.Second)
+	clock.Advance(2 * time.Second)
 
 	v, _ := c.Get(ctx, "ttlKey")
 
@@ -218,3 +237,2046 @@ func TestConcurrentGet(t *testing.T) {
 
 	wg.Wait()
 }
+
+//
+// ================= SHARD STORES =================
+//
+
+func TestStoreTypes(t *testing.T) {
+	for _, st := range []shard.StoreType{shard.COW, shard.Mutable} {
+		t.Run(string(st), func(t *testing.T) {
+			ctx := context.Background()
+			c, store := newTestCache(4, cache.WithStore(st))
+
+			c.Put(ctx, "key1", "value1")
+			c.Put(ctx, "key1", "value2")
+			if v, _ := c.Get(ctx, "key1"); v != "value2" {
+				t.Fatalf("expected value2, got %v", v)
+			}
+
+			// Put goes through write-back: let value2 reach the store before deleting it there
+			waitFor(t, "write-back of value2", func() bool { v, _ := store.Load(ctx, "key1"); return v == "value2" })
+			c.Remove("key1")
+			store.Delete("key1")
+			if v, _ := c.Get(ctx, "key1"); v != nil {
+				t.Fatalf("expected nil after remove, got %v", v)
+			}
+
+			// concurrent writers and readers must not race on the store
+			wg := sync.WaitGroup{}
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(id int) {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						key := fmt.Sprintf("k%d", j%10)
+						c.Put(ctx, key, id)
+						c.Get(ctx, key)
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+//
+// ================= WEIGHT-BASED CAPACITY =================
+//
+
+func TestWeigherEvictsUntilFit(t *testing.T) {
+	ctx := context.Background()
+	store := NewTestStore()
+
+	weigher := func(key string, value any) int64 {
+		return int64(len(value.(string)))
+	}
+
+	engine := engine.NewCacheEngine(nil, nil, store, nil, nil)
+	c := cache.NewShardedCache(1, 0, eviction.LRU, engine, cache.WithWeigher(weigher, 10))
+
+	c.Put(ctx, "a", "aaaa")
+	c.Put(ctx, "b", "bbbb")
+
+	// 8 bytes only fit once both previous entries are gone
+	if err := c.Put(ctx, "c", "cccccccc"); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	for _, k := range []string{"a", "b"} {
+		if v, _ := c.Get(ctx, k); v != nil {
+			t.Fatalf("expected %s to be evicted, got %v", k, v)
+		}
+	}
+	if v, _ := c.Get(ctx, "c"); v != "cccccccc" {
+		t.Fatalf("expected cccccccc, got %v", v)
+	}
+
+	// larger than the whole shard budget
+	if err := c.Put(ctx, "d", "ddddddddddd"); err != cache.ErrEntryTooLarge {
+		t.Fatalf("expected ErrEntryTooLarge, got %v", err)
+	}
+}
+
+func TestSmallMaxWeightIsNotExceededBySharding(t *testing.T) {
+	ctx := context.Background()
+	weigher := func(key string, value any) int64 { return 1 }
+
+	engine := engine.NewCacheEngine(nil, nil, NewTestStore(), nil, nil)
+	c := cache.NewShardedCache(16, 0, eviction.LRU, engine, cache.WithWeigher(weigher, 3))
+	defer c.Close()
+
+	for i := 0; i < 50; i++ {
+		c.Put(ctx, fmt.Sprintf("key-%d", i), i)
+	}
+	cached := 0
+	for i := 0; i < 50; i++ {
+		if v, _ := c.Get(ctx, fmt.Sprintf("key-%d", i)); v != nil {
+			cached++
+		}
+	}
+	if cached == 0 || cached > 3 {
+		t.Fatalf("expected at most 3 entries cached, got %d", cached)
+	}
+}
+
+//
+// ================= EVICTION POLICIES =================
+//
+
+// countingMetrics records hits and misses so tests can compute hit ratios.
+type countingMetrics struct {
+	types.NoopMetrics
+	mu     sync.Mutex
+	hits   int
+	misses int
+}
+
+func (m *countingMetrics) Hit()  { m.mu.Lock(); m.hits++; m.mu.Unlock() }
+func (m *countingMetrics) Miss() { m.mu.Lock(); m.misses++; m.mu.Unlock() }
+
+func (m *countingMetrics) hitRatio() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return float64(m.hits) / float64(m.hits+m.misses)
+}
+
+// newPolicyCache creates a single-shard cache so eviction order is fully visible.
+func newPolicyCache(capacity int, policy eviction.PolicyType) (*cache.ShardedCache, *TestStore, *countingMetrics) {
+	store := NewTestStore()
+	metrics := &countingMetrics{}
+	engine := engine.NewCacheEngine(nil, nil, store, nil, metrics)
+	return cache.NewShardedCache(1, capacity, policy, engine), store, metrics
+}
+
+// assertSurvivesScan fills a hot set, reads it, then scans many one-off keys.
+// The hot keys are not in the backing store, so a nil Get means they were evicted.
+func assertSurvivesScan(t *testing.T, policy eviction.PolicyType) {
+	ctx := context.Background()
+	c, _, _ := newPolicyCache(200, policy)
+
+	for i := 0; i < 50; i++ {
+		c.Put(ctx, fmt.Sprintf("hot-%d", i), i)
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 50; i++ {
+			c.Get(ctx, fmt.Sprintf("hot-%d", i))
+		}
+	}
+
+	for i := 0; i < 2000; i++ {
+		c.Put(ctx, fmt.Sprintf("scan-%d", i), i)
+	}
+
+	for i := 0; i < 50; i++ {
+		if v, _ := c.Get(ctx, fmt.Sprintf("hot-%d", i)); v != i {
+			t.Fatalf("hot-%d was flushed by the scan", i)
+		}
+	}
+}
+
+// zipfHitRatio replays a read-through Zipf workload and returns the hit ratio.
+func zipfHitRatio(policy eviction.PolicyType) float64 {
+	ctx := context.Background()
+	c, store, metrics := newPolicyCache(100, policy)
+
+	for i := 0; i < 10000; i++ {
+		store.data[fmt.Sprintf("key-%d", i)] = i
+	}
+
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 9999)
+	for i := 0; i < 50000; i++ {
+		c.Get(ctx, fmt.Sprintf("key-%d", zipf.Uint64()))
+	}
+	return metrics.hitRatio()
+}
+
+func TestTinyLFUSurvivesScan(t *testing.T) {
+	assertSurvivesScan(t, eviction.TinyLFU)
+}
+
+func TestTinyLFUZipfHitRatio(t *testing.T) {
+	lru := zipfHitRatio(eviction.LRU)
+	tiny := zipfHitRatio(eviction.TinyLFU)
+	if tiny <= lru {
+		t.Fatalf("expected TinyLFU hit ratio above LRU, got %.3f <= %.3f", tiny, lru)
+	}
+}
+
+func TestARCSurvivesScan(t *testing.T) {
+	assertSurvivesScan(t, eviction.ARC)
+}
+
+func TestARCAdaptsBetweenScansAndHotSet(t *testing.T) {
+	ctx := context.Background()
+	c, store, metrics := newPolicyCache(100, eviction.ARC)
+
+	for i := 0; i < 10000; i++ {
+		store.data[fmt.Sprintf("key-%d", i)] = i
+	}
+
+	// Alternate a stable hot set with batch scans; the hot set must keep hitting
+	for phase := 0; phase < 5; phase++ {
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 50; i++ {
+				c.Get(ctx, fmt.Sprintf("key-%d", i))
+			}
+		}
+		for i := 0; i < 500; i++ {
+			c.Get(ctx, fmt.Sprintf("key-%d", 1000+phase*500+i))
+		}
+	}
+
+	// 50 cold misses + 2500 scan misses is the floor; allow a little slack
+	if metrics.misses > 2600 {
+		t.Fatalf("expected hot set to survive scans, got %d misses", metrics.misses)
+	}
+}
+
+// Hits update the eviction policy without the shard lock on the read path; run with -race.
+func TestConcurrentHitsKeepPoliciesConsistent(t *testing.T) {
+	for _, policy := range []eviction.PolicyType{eviction.LRU, eviction.LFU, eviction.S3FIFO, eviction.TinyLFU, eviction.ARC} {
+		t.Run(string(policy), func(t *testing.T) {
+			ctx := context.Background()
+			c, store, _ := newPolicyCache(50, policy)
+			for i := 0; i < 200; i++ {
+				store.data[fmt.Sprintf("key-%d", i)] = i
+			}
+
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for j := 0; j < 2000; j++ {
+						key := fmt.Sprintf("key-%d", (g*31+j*7)%200)
+						if v, err := c.Get(ctx, key); v == nil || err != nil {
+							t.Errorf("expected %s to load, got %v, %v", key, v, err)
+							return
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+
+			// The policy still agrees with the store: once emptied, the cache holds a full load of new keys
+			for i := 0; i < 200; i++ {
+				c.Remove(fmt.Sprintf("key-%d", i))
+			}
+			for i := 0; i < 50; i++ {
+				c.Put(ctx, fmt.Sprintf("fresh-%d", i), i)
+			}
+			for i := 0; i < 50; i++ {
+				if v, _ := c.Get(ctx, fmt.Sprintf("fresh-%d", i)); v != i {
+					t.Fatalf("expected fresh-%d to be cached, got %v", i, v)
+				}
+			}
+		})
+	}
+}
+
+func TestS3FIFOSurvivesScan(t *testing.T) {
+	assertSurvivesScan(t, eviction.S3FIFO)
+}
+
+func TestS3FIFOZipfHitRatio(t *testing.T) {
+	fifo := zipfHitRatio(eviction.FIFO)
+	s3 := zipfHitRatio(eviction.S3FIFO)
+	if s3 <= fifo {
+		t.Fatalf("expected S3-FIFO hit ratio above FIFO, got %.3f <= %.3f", s3, fifo)
+	}
+}
+
+//
+// ================= REMOVAL LISTENER =================
+//
+
+func TestRemovalListenerCauses(t *testing.T) {
+	ctx := context.Background()
+	store := NewTestStore()
+
+	var got []string
+	var c *cache.ShardedCache
+	listener := func(key string, value any, cause types.RemovalCause) {
+		got = append(got, fmt.Sprintf("%s=%v:%s", key, value, cause))
+		// runs off the shard lock, so calling back into the cache must not deadlock
+		c.TTL(key)
+	}
+
+	clock := cachetest.NewFakeClock(time.Now())
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterAccess{TTL: time.Minute}, nil, store, nil, nil)
+	engine.Clock = clock
+	c = cache.NewShardedCache(1, 2, eviction.FIFO, engine, cache.WithRemovalListener(listener))
+
+	c.Put(ctx, "replaced", "v1")
+	c.Put(ctx, "replaced", "v2")
+
+	c.Put(ctx, "explicit", "v")
+	c.Remove("explicit")
+
+	c.PutWithTTL(ctx, "expired", "v", time.Millisecond)
+	clock.Advance(time.Second)
+	c.Get(ctx, "expired")
+
+	// shard holds 2 entries: "replaced" is the oldest and gets evicted
+	c.Put(ctx, "a", "v")
+	c.Put(ctx, "b", "v")
+
+	want := []string{
+		"replaced=v1:replaced",
+		"explicit=v:explicit",
+		"expired=v:expired",
+		"replaced=v2:evicted",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+//
+// ================= ACTIVE EXPIRATION =================
+//
+
+// waitFor polls cond until it holds, for checks that depend on background goroutines.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// expiredKeys is a removal listener that records which keys expired.
+type expiredKeys struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (e *expiredKeys) listen(key string, value any, cause types.RemovalCause) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cause == types.CauseExpired {
+		e.keys[key] = true
+	}
+}
+
+func (e *expiredKeys) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.keys)
+}
+
+func (e *expiredKeys) has(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.keys[key]
+}
+
+// newBackgroundCache creates a cache on a fake clock with the given background expiration option.
+func newBackgroundCache(opt cache.Option) (*cache.ShardedCache, *cachetest.FakeClock, *expiredKeys) {
+	clock := cachetest.NewFakeClock(time.Now())
+	expired := &expiredKeys{keys: map[string]bool{}}
+
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterAccess{TTL: time.Minute}, nil, NewTestStore(), nil, nil)
+	engine.Clock = clock
+
+	c := cache.NewShardedCache(4, 1000, eviction.LRU, engine, cache.WithRemovalListener(expired.listen), opt)
+	return c, clock, expired
+}
+
+func TestActiveExpirationRemovesUnreadKeys(t *testing.T) {
+	ctx := context.Background()
+	c, clock, expired := newBackgroundCache(cache.WithActiveExpiration(time.Millisecond, time.Millisecond))
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		c.PutWithTTL(ctx, fmt.Sprintf("short-%d", i), i, time.Second)
+		c.Put(ctx, fmt.Sprintf("long-%d", i), i)
+	}
+	clock.Advance(2 * time.Second)
+
+	// Nobody reads the short keys; the sweeper alone must remove them
+	waitFor(t, "sweeper to remove 100 keys", func() bool { return expired.count() == 100 })
+
+	if c.TTL("long-1") <= 0 {
+		t.Fatalf("expected long-lived key to survive the sweeper")
+	}
+
+	// A non-positive interval disables the sweeper instead of panicking
+	for _, interval := range []time.Duration{0, -time.Second} {
+		c, _, _ := newBackgroundCache(cache.WithActiveExpiration(interval, time.Millisecond))
+		c.Close()
+	}
+}
+
+//
+// ================= TIMING WHEEL =================
+//
+
+func TestTimingWheelExpiresOnDeadline(t *testing.T) {
+	ctx := context.Background()
+	c, clock, expired := newBackgroundCache(cache.WithTimingWheel(time.Millisecond))
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		c.PutWithTTL(ctx, fmt.Sprintf("key-%d", i), i, 20*time.Millisecond)
+	}
+
+	// Expire moves a deadline, Remove cancels one
+	c.Expire("key-0", time.Hour)
+	c.Remove("key-1")
+
+	clock.Advance(100 * time.Millisecond)
+	waitFor(t, "wheel to expire 98 keys", func() bool { return expired.count() == 98 })
+
+	if expired.has("key-0") || expired.has("key-1") {
+		t.Fatalf("rescheduled or removed keys must not expire")
+	}
+	if c.TTL("key-0") <= 0 {
+		t.Fatalf("expected key-0 to still be cached")
+	}
+}
+
+//
+// ================= EXPIRATION STRATEGIES =================
+//
+
+func newStrategyCache(exp expiration.Strategy) (*cache.ShardedCache, *cachetest.FakeClock) {
+	clock := cachetest.NewFakeClock(time.Now())
+	engine := engine.NewCacheEngine(exp, nil, NewTestStore(), nil, nil)
+	engine.Clock = clock
+	return cache.NewShardedCache(1, 10, eviction.LRU, engine), clock
+}
+
+func TestExpireAfterWriteIgnoresReads(t *testing.T) {
+	ctx := context.Background()
+	c, clock := newStrategyCache(&expiration.ExpireAfterWrite{TTL: 30 * time.Second})
+
+	c.Put(ctx, "key", "value")
+
+	// Reading keeps an ExpireAfterAccess entry alive, but not this one
+	for i := 0; i < 5; i++ {
+		clock.Advance(10 * time.Second)
+		c.Get(ctx, "key")
+	}
+
+	if v, _ := c.Get(ctx, "key"); v != nil {
+		t.Fatalf("expected key to expire after write TTL, got %v", v)
+	}
+}
+
+func TestIdleWithMaxLifetime(t *testing.T) {
+	ctx := context.Background()
+	c, clock := newStrategyCache(&expiration.IdleWithMaxLifetime{
+		Idle:        30 * time.Second,
+		MaxLifetime: 60 * time.Second,
+	})
+
+	// Idle timeout: an unread entry expires
+	c.Put(ctx, "idle", "value")
+	clock.Advance(40 * time.Second)
+	if v, _ := c.Get(ctx, "idle"); v != nil {
+		t.Fatalf("expected idle key to expire, got %v", v)
+	}
+
+	// Max lifetime: frequent reads keep it alive only until MaxLifetime
+	c.Put(ctx, "busy", "value")
+	for i := 0; i < 5; i++ {
+		clock.Advance(10 * time.Second)
+		if v, _ := c.Get(ctx, "busy"); v != "value" {
+			t.Fatalf("expected busy key to be alive at %d, got %v", i, v)
+		}
+	}
+	clock.Advance(11 * time.Second)
+	if v, _ := c.Get(ctx, "busy"); v != nil {
+		t.Fatalf("expected busy key to expire after max lifetime, got %v", v)
+	}
+
+	// Explicit TTL is kept on write
+	c.PutWithTTL(ctx, "explicit", "value", time.Hour)
+	if ttl := c.TTL("explicit"); ttl != time.Hour {
+		t.Fatalf("expected explicit TTL to be kept, got %v", ttl)
+	}
+}
+
+//
+// ================= PER-ENTRY EXPIRY =================
+//
+
+// token carries its own expiry, like a JWT "exp" claim.
+type token struct {
+	exp time.Time
+}
+
+// tokenExpiry lets every token live until its own exp; reads never extend it.
+type tokenExpiry struct{}
+
+func (tokenExpiry) ExpireAfterCreate(key string, value any, now time.Time) time.Duration {
+	return value.(token).exp.Sub(now)
+}
+
+func (tokenExpiry) ExpireAfterUpdate(key string, value any, now time.Time, current time.Duration) time.Duration {
+	return value.(token).exp.Sub(now)
+}
+
+func (tokenExpiry) ExpireAfterRead(key string, value any, now time.Time, current time.Duration) time.Duration {
+	return current
+}
+
+func TestExpiryFromValue(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	store := NewTestStore()
+	store.data["short"] = token{exp: clock.Now().Add(time.Minute)}
+	store.data["long"] = token{exp: clock.Now().Add(time.Hour)}
+
+	engine := engine.NewCacheEngine(nil, nil, store, nil, nil)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithExpiry(tokenExpiry{}))
+
+	// Read-through loads get their TTL from the value
+	c.Get(ctx, "short")
+	c.Get(ctx, "long")
+	if ttl := c.TTL("long"); ttl != time.Hour {
+		t.Fatalf("expected long token TTL of 1h, got %v", ttl)
+	}
+
+	// Explicit TTL wins over the computed one
+	c.PutWithTTL(ctx, "explicit", token{exp: clock.Now().Add(time.Hour)}, time.Minute)
+	if ttl := c.TTL("explicit"); ttl != time.Minute {
+		t.Fatalf("expected explicit TTL, got %v", ttl)
+	}
+
+	clock.Advance(2 * time.Minute)
+	store.Delete("short")
+	if v, _ := c.Get(ctx, "short"); v != nil {
+		t.Fatalf("expected short token to expire, got %v", v)
+	}
+}
+
+// slidingExpiry extends every token to an hour when it is read.
+type slidingExpiry struct {
+	tokenExpiry
+}
+
+func (slidingExpiry) ExpireAfterRead(key string, value any, now time.Time, current time.Duration) time.Duration {
+	return time.Hour
+}
+
+func TestExpiryKeepsExplicitTTLOnRead(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	engine := engine.NewCacheEngine(nil, nil, NewTestStore(), nil, nil)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithExpiry(slidingExpiry{}))
+
+	c.PutWithTTL(ctx, "explicit", token{exp: clock.Now().Add(time.Hour)}, time.Second)
+	c.Put(ctx, "computed", token{exp: clock.Now().Add(time.Minute)})
+	c.Get(ctx, "explicit")
+	c.Get(ctx, "computed")
+
+	if ttl := c.TTL("explicit"); ttl != time.Second {
+		t.Fatalf("expected a read to keep the explicit TTL, got %v", ttl)
+	}
+	if ttl := c.TTL("computed"); ttl != time.Hour {
+		t.Fatalf("expected a read to extend the computed TTL, got %v", ttl)
+	}
+}
+
+//
+// ================= REFRESH AHEAD =================
+//
+
+// countingLoader wraps TestStore, counting loads and optionally holding them until released.
+type countingLoader struct {
+	*TestStore
+	loads atomic.Int64
+	gate  chan struct{}
+}
+
+func (l *countingLoader) Load(ctx context.Context, key string) (any, error) {
+	l.loads.Add(1)
+	if l.gate != nil {
+		<-l.gate
+	}
+	return l.TestStore.Load(ctx, key)
+}
+
+// refreshMetrics counts refreshes.
+type refreshMetrics struct {
+	types.NoopMetrics
+	refreshes atomic.Int64
+}
+
+func (m *refreshMetrics) Refresh() { m.refreshes.Add(1) }
+
+func TestRefreshAfterWriteReloadsInBackground(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	loader := &countingLoader{TestStore: NewTestStore(), gate: make(chan struct{})}
+	metrics := &refreshMetrics{}
+
+	hook := refresh.NewRefreshAfterWrite(time.Minute, 4)
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterWrite{TTL: time.Hour}, hook, loader, nil, metrics)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine)
+	defer c.Close()
+
+	c.Put(ctx, "key", "v1")
+	loader.data["key"] = "v2"
+
+	// Young entry: no reload
+	c.Get(ctx, "key")
+	if n := loader.loads.Load(); n != 0 {
+		t.Fatalf("expected no reload before threshold, got %d", n)
+	}
+
+	// Old entry: many readers, current value served, exactly one reload
+	clock.Advance(2 * time.Minute)
+	for i := 0; i < 10; i++ {
+		if v, _ := c.Get(ctx, "key"); v != "v1" {
+			t.Fatalf("expected current value while reloading, got %v", v)
+		}
+	}
+	close(loader.gate)
+
+	waitFor(t, "refreshed value", func() bool {
+		v, _ := c.Get(ctx, "key")
+		return v == "v2"
+	})
+	if n := loader.loads.Load(); n != 1 {
+		t.Fatalf("expected exactly one deduplicated reload, got %d", n)
+	}
+	if n := metrics.refreshes.Load(); n != 1 {
+		t.Fatalf("expected one refresh metric, got %d", n)
+	}
+}
+
+func TestRefreshKeepsExplicitTTLAndRemovals(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	loader := &countingLoader{TestStore: NewTestStore(), gate: make(chan struct{})}
+
+	metrics := &refreshMetrics{}
+
+	hook := refresh.NewRefreshAfterWrite(time.Minute, 4)
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterWrite{TTL: time.Hour}, hook, loader, nil, metrics)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine)
+
+	c.PutWithTTL(ctx, "ttl", "v1", 10*time.Minute)
+	c.Put(ctx, "gone", "v1")
+	loader.data["ttl"] = "v2"
+	loader.data["gone"] = "v2"
+
+	// Both keys start reloading; one is removed before its reload finishes
+	clock.Advance(2 * time.Minute)
+	c.Get(ctx, "ttl")
+	c.Get(ctx, "gone")
+	c.Remove("gone")
+	close(loader.gate)
+	c.Close()
+
+	if c.TTL("gone") != -1 {
+		t.Fatalf("expected removed key to stay removed after its refresh")
+	}
+	if ttl := c.TTL("ttl"); ttl <= 0 || ttl > 8*time.Minute {
+		t.Fatalf("expected the explicit deadline to survive the refresh, got %v", ttl)
+	}
+
+	// Only the reload that was stored counts as a refresh
+	if n := metrics.refreshes.Load(); n != 1 {
+		t.Fatalf("expected 1 refresh, got %d", n)
+	}
+}
+
+// slowLoader advances a fake clock on every load, simulating an expensive recompute.
+// Loads fail while err is set.
+type slowLoader struct {
+	*countingLoader
+	clock *cachetest.FakeClock
+	took  time.Duration
+	err   error
+}
+
+func (l *slowLoader) Load(ctx context.Context, key string) (any, error) {
+	l.clock.Advance(l.took)
+	v, err := l.countingLoader.Load(ctx, key)
+	if l.err != nil {
+		return nil, l.err
+	}
+	return v, err
+}
+
+func TestEarlyExpirationRecomputesBeforeDeadline(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	loader := &slowLoader{countingLoader: &countingLoader{TestStore: NewTestStore()}, clock: clock, took: time.Second}
+	loader.data["key"] = "v1"
+
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterWrite{TTL: time.Minute}, nil, loader, nil, nil)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithEarlyExpiration(1))
+	defer c.Close()
+
+	c.Get(ctx, "key")
+	loader.data["key"] = "v2"
+
+	// Far from the deadline: every read is a hit
+	clock.Advance(10 * time.Second)
+	for i := 0; i < 100; i++ {
+		if v, _ := c.Get(ctx, "key"); v != "v1" {
+			t.Fatalf("expected cached value far from deadline, got %v", v)
+		}
+	}
+	if n := loader.loads.Load(); n != 1 {
+		t.Fatalf("expected no early reload far from deadline, got %d loads", n)
+	}
+
+	// Just before the deadline: one reader recomputes, the rest keep hitting
+	clock.Advance(50*time.Second - 10*time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		c.Get(ctx, "key")
+	}
+	if n := loader.loads.Load(); n != 2 {
+		t.Fatalf("expected exactly one early reload, got %d loads", n-1)
+	}
+	if v, _ := c.Get(ctx, "key"); v != "v2" {
+		t.Fatalf("expected reloaded value, got %v", v)
+	}
+}
+
+func TestEarlyExpirationKeepsServingWhenTheReloadFails(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	loader := &slowLoader{countingLoader: &countingLoader{TestStore: NewTestStore()}, clock: clock, took: time.Second}
+	loader.data["key"] = "v1"
+
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterWrite{TTL: time.Minute}, nil, loader, nil, nil)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithEarlyExpiration(1))
+	defer c.Close()
+
+	c.Get(ctx, "key")
+	loader.took = 0
+	clock.Advance(time.Minute - 10*time.Millisecond)
+
+	// The early reload fails, or finds nothing: the entry is still valid, so it is served
+	loader.err = errors.New("db down")
+	for i := 0; i < 1000; i++ {
+		if v, err := c.Get(ctx, "key"); v != "v1" || err != nil {
+			t.Fatalf("expected the live value while the early reload fails, got %v, %v", v, err)
+		}
+	}
+	loader.err = nil
+	delete(loader.data, "key")
+	for i := 0; i < 1000; i++ {
+		if v, err := c.Get(ctx, "key"); v != "v1" || err != nil {
+			t.Fatalf("expected the live value while the early reload finds nothing, got %v, %v", v, err)
+		}
+	}
+	if n := loader.loads.Load(); n < 3 {
+		t.Fatalf("expected early reloads in both phases, got %d loads", n)
+	}
+}
+
+// failingLoader wraps TestStore, counts loads and fails every load while err is set.
+type failingLoader struct {
+	*TestStore
+	err   error
+	loads atomic.Int64
+}
+
+func (l *failingLoader) Load(ctx context.Context, key string) (any, error) {
+	l.loads.Add(1)
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.TestStore.Load(ctx, key)
+}
+
+// staleMetrics counts stale serves.
+type staleMetrics struct {
+	types.NoopMetrics
+	stale atomic.Int64
+}
+
+func (m *staleMetrics) Stale() { m.stale.Add(1) }
+
+func TestStaleIfErrorServesExpiredValue(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	loader := &failingLoader{TestStore: NewTestStore()}
+	metrics := &staleMetrics{}
+	errDown := errors.New("backing store down")
+
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterWrite{TTL: time.Minute}, nil, loader, nil, metrics)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithStaleIfError(30*time.Second))
+	defer c.Close()
+
+	c.Put(ctx, "key", "v1")
+
+	// Expired, within grace, loader down: stale value with an indicator
+	clock.Advance(70 * time.Second)
+	loader.err = errDown
+	v, err := c.Get(ctx, "key")
+	if v != "v1" || !errors.Is(err, cache.ErrStale) || !errors.Is(err, errDown) {
+		t.Fatalf("expected stale v1 with ErrStale, got %v, %v", v, err)
+	}
+	if n := metrics.stale.Load(); n != 1 {
+		t.Fatalf("expected 1 stale serve, got %d", n)
+	}
+
+	// Past the grace window: the error is returned as is
+	clock.Advance(30 * time.Second)
+	v, err = c.Get(ctx, "key")
+	if v != nil || !errors.Is(err, errDown) || errors.Is(err, cache.ErrStale) {
+		t.Fatalf("expected plain load error after grace, got %v, %v", v, err)
+	}
+
+	// Within grace and the loader recovers: the fresh value replaces the stale one
+	c.Put(ctx, "key", "v1")
+	clock.Advance(70 * time.Second)
+	loader.err = nil
+	loader.data["key"] = "v2"
+	if v, err := c.Get(ctx, "key"); v != "v2" || err != nil {
+		t.Fatalf("expected reloaded v2, got %v, %v", v, err)
+	}
+}
+
+// batchLoader is a TestStore that can load many keys at once, recording each batch.
+type batchLoader struct {
+	*TestStore
+	mu      sync.Mutex
+	batches [][]string
+
+	// failKey, if set, is left out of every batch, which then fails.
+	failKey string
+
+	// gate, if set, holds every batch until it is closed.
+	gate chan struct{}
+}
+
+func (l *batchLoader) LoadAll(ctx context.Context, keys []string) (map[string]any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.batches = append(l.batches, append([]string(nil), keys...))
+	l.mu.Unlock()
+
+	if l.gate != nil {
+		<-l.gate
+	}
+
+	var err error
+	vals := make(map[string]any, len(keys))
+	for _, key := range keys {
+		if key == l.failKey {
+			err = errors.New("load failed")
+			continue
+		}
+		if v, _ := l.TestStore.Load(ctx, key); v != nil {
+			vals[key] = v
+		}
+	}
+	return vals, err
+}
+
+func TestGetAllLoadsMissingKeysInOneBatch(t *testing.T) {
+	ctx := context.Background()
+	loader := &batchLoader{TestStore: NewTestStore()}
+
+	engine := engine.NewCacheEngine(nil, nil, loader, nil, nil)
+	c := cache.NewShardedCache(4, 100, eviction.LRU, engine)
+	defer c.Close()
+
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		loader.data[keys[i]] = i
+	}
+	c.Put(ctx, "key-0", 0)
+
+	got, err := c.GetAll(ctx, append(keys, "missing"))
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if len(got) != len(keys) {
+		t.Fatalf("expected %d values, got %d", len(keys), len(got))
+	}
+	for i, key := range keys {
+		if got[key] != i {
+			t.Fatalf("expected %s=%d, got %v", key, i, got[key])
+		}
+	}
+	if len(loader.batches) != 1 || len(loader.batches[0]) != len(keys) {
+		t.Fatalf("expected one batch of %d missing keys, got %v", len(keys), loader.batches)
+	}
+
+	// Everything is cached now: no more round trips
+	c.GetAll(ctx, keys)
+	if len(loader.batches) != 1 {
+		t.Fatalf("expected cached GetAll to skip the loader, got %d batches", len(loader.batches))
+	}
+
+	// A partial failure only fails the keys that did not load
+	loader.failKey = "bad"
+	loader.data["good"] = "g"
+	got, err = c.GetAll(ctx, []string{"good", "bad"})
+	if err == nil || got["good"] != "g" {
+		t.Fatalf("expected good value with the error, got %v, %v", got, err)
+	}
+	if v, _ := c.Get(ctx, "good"); v != "g" || len(loader.batches) != 2 {
+		t.Fatalf("expected good to be cached, got %v after %d batches", v, len(loader.batches))
+	}
+}
+
+func TestNegativeCacheStopsRepeatedLoads(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	loader := &countingLoader{TestStore: NewTestStore()}
+
+	engine := engine.NewCacheEngine(nil, nil, loader, nil, nil)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithNegativeCache(time.Second, 2))
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.Get(ctx, "ghost"); !errors.Is(err, cache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if n := loader.loads.Load(); n != 1 {
+		t.Fatalf("expected one load for a missing key, got %d", n)
+	}
+
+	// The negative entry expires after its TTL
+	clock.Advance(2 * time.Second)
+	c.Get(ctx, "ghost")
+	if n := loader.loads.Load(); n != 2 {
+		t.Fatalf("expected a reload after the negative TTL, got %d loads", n)
+	}
+
+	// Put clears the negative entry
+	c.Put(ctx, "ghost", "boo")
+	if v, err := c.Get(ctx, "ghost"); v != "boo" || err != nil {
+		t.Fatalf("expected put value, got %v, %v", v, err)
+	}
+
+	// The negative cache is bounded: the oldest missing key is forgotten first
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+	c.Get(ctx, "c")
+	before := loader.loads.Load()
+	c.Get(ctx, "a")
+	if n := loader.loads.Load(); n != before+1 {
+		t.Fatalf("expected the oldest negative key to be dropped, got %d new loads", n-before)
+	}
+}
+
+// blockingLoader holds every load until the gate is closed or the load's ctx is cancelled.
+type blockingLoader struct {
+	*TestStore
+	loads     atomic.Int64
+	gate      chan struct{}
+	cancelled chan string
+}
+
+func (l *blockingLoader) Load(ctx context.Context, key string) (any, error) {
+	l.loads.Add(1)
+	select {
+	case <-l.gate:
+		return l.TestStore.Load(ctx, key)
+	case <-ctx.Done():
+		l.cancelled <- key
+		return nil, ctx.Err()
+	}
+}
+
+func TestLoadCancellationIsPerCaller(t *testing.T) {
+	loader := &blockingLoader{TestStore: NewTestStore(), gate: make(chan struct{}), cancelled: make(chan string, 1)}
+	loader.data["shared"] = "value"
+
+	engine := engine.NewCacheEngine(nil, nil, loader, nil, nil)
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine)
+	defer c.Close()
+
+	// The first caller starts the load and keeps waiting
+	result := make(chan any)
+	go func() {
+		v, _ := c.Get(context.Background(), "shared")
+		result <- v
+	}()
+	waitFor(t, "the load to start", func() bool { return loader.loads.Load() == 1 })
+
+	// A second caller whose ctx is done returns right away, without cancelling the load
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Get(ctx, "shared"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the waiter's own cancellation, got %v", err)
+	}
+
+	close(loader.gate)
+	if v := <-result; v != "value" {
+		t.Fatalf("expected the load to finish for the remaining caller, got %v", v)
+	}
+	if n := loader.loads.Load(); n != 1 {
+		t.Fatalf("expected one shared load, got %d", n)
+	}
+
+	// When every caller has gone, the load is abandoned
+	loader.gate = make(chan struct{})
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		for loader.loads.Load() < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := c.Get(ctx, "alone"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	select {
+	case key := <-loader.cancelled:
+		if key != "alone" {
+			t.Fatalf("expected the abandoned load to be cancelled, got %s", key)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("abandoned load was not cancelled")
+	}
+}
+
+// flakyLoader fails the first `failures` loads, then succeeds.
+type flakyLoader struct {
+	*TestStore
+	failures int64
+	loads    atomic.Int64
+}
+
+func (l *flakyLoader) Load(ctx context.Context, key string) (any, error) {
+	if l.loads.Add(1) <= l.failures {
+		return nil, errors.New("transient failure")
+	}
+	return l.TestStore.Load(ctx, key)
+}
+
+func TestLoaderRetryWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	backoff := loader.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Jitter: 0.5}
+
+	store := &flakyLoader{TestStore: NewTestStore(), failures: 2}
+	store.data["key"] = "value"
+	if v, err := loader.Chain(store, loader.Retry(3, backoff)).Load(ctx, "key"); v != "value" || err != nil {
+		t.Fatalf("expected success on the third attempt, got %v, %v", v, err)
+	}
+
+	store = &flakyLoader{TestStore: NewTestStore(), failures: 2}
+	if _, err := loader.Chain(store, loader.Retry(2, backoff)).Load(ctx, "key"); err == nil {
+		t.Fatal("expected failure once attempts run out")
+	}
+	if n := store.loads.Load(); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+}
+
+// breakerMetrics records circuit breaker state changes.
+type breakerMetrics struct {
+	types.NoopMetrics
+	mu     sync.Mutex
+	states []types.BreakerState
+}
+
+func (m *breakerMetrics) BreakerStateChanged(name string, state types.BreakerState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states = append(m.states, state)
+}
+
+func TestCircuitBreakerFailsFastAndServesStale(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	metrics := &breakerMetrics{}
+	errDown := errors.New("backing store down")
+
+	breaker := loader.NewCircuitBreaker("db", 2, time.Minute)
+	breaker.Metrics = metrics
+	breaker.Clock = clock
+
+	store := &failingLoader{TestStore: NewTestStore()}
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterWrite{TTL: time.Minute}, nil, loader.Chain(store, breaker.Middleware), nil, metrics)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithStaleIfError(time.Minute))
+	defer c.Close()
+
+	c.Put(ctx, "key", "v1")
+	clock.Advance(70 * time.Second)
+	store.err = errDown
+
+	// Two failures open the breaker
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+	if s := breaker.State(); s != types.BreakerOpen {
+		t.Fatalf("expected open breaker, got %s", s)
+	}
+
+	// Open: fail fast without reaching the backing store, or serve stale
+	if _, err := c.Get(ctx, "c"); !errors.Is(err, loader.ErrBreakerOpen) {
+		t.Fatalf("expected ErrBreakerOpen, got %v", err)
+	}
+	v, err := c.Get(ctx, "key")
+	if v != "v1" || !errors.Is(err, cache.ErrStale) || !errors.Is(err, loader.ErrBreakerOpen) {
+		t.Fatalf("expected stale value while open, got %v, %v", v, err)
+	}
+	if n := store.loads.Load(); n != 2 {
+		t.Fatalf("expected no loads while open, got %d", n-2)
+	}
+
+	// After the cooldown a successful trial call closes the breaker
+	clock.Advance(time.Minute)
+	store.err = nil
+	store.data["key"] = "v2"
+	if v, err := c.Get(ctx, "key"); v != "v2" || err != nil {
+		t.Fatalf("expected reload through the half-open breaker, got %v, %v", v, err)
+	}
+
+	want := []types.BreakerState{types.BreakerOpen, types.BreakerHalfOpen, types.BreakerClosed}
+	if fmt.Sprint(metrics.states) != fmt.Sprint(want) {
+		t.Fatalf("expected state changes %v, got %v", want, metrics.states)
+	}
+}
+
+// scriptedLoader holds loads of the keys in gates until their gate is closed, and fails loads of keys starting with "bad".
+type scriptedLoader struct {
+	*TestStore
+	gates   map[string]chan struct{}
+	entered chan string
+}
+
+func (l *scriptedLoader) Load(ctx context.Context, key string) (any, error) {
+	l.entered <- key
+	if gate := l.gates[key]; gate != nil {
+		<-gate
+	}
+	if strings.HasPrefix(key, "bad") {
+		return nil, errors.New("bad key")
+	}
+	return l.TestStore.Load(ctx, key)
+}
+
+func TestCircuitBreakerIgnoresCallsFromBeforeItOpened(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	breaker := loader.NewCircuitBreaker("db", 1, time.Minute)
+	breaker.Clock = clock
+
+	store := &scriptedLoader{
+		TestStore: NewTestStore(),
+		gates:     map[string]chan struct{}{"bad-slow": make(chan struct{}), "probe": make(chan struct{})},
+		entered:   make(chan string, 4),
+	}
+	l := breaker.Middleware(store)
+
+	// A slow call goes through while closed, then another call opens the breaker
+	slow := make(chan error)
+	go func() { _, err := l.Load(ctx, "bad-slow"); slow <- err }()
+	<-store.entered
+	l.Load(ctx, "bad")
+	<-store.entered
+
+	// Half-open: the trial call is running when the slow call finally fails
+	clock.Advance(time.Minute)
+	probe := make(chan error)
+	go func() { _, err := l.Load(ctx, "probe"); probe <- err }()
+	<-store.entered
+	close(store.gates["bad-slow"])
+	if err := <-slow; err == nil {
+		t.Fatalf("expected the slow call to fail")
+	}
+
+	if s := breaker.State(); s != types.BreakerHalfOpen {
+		t.Fatalf("expected the stale call to leave the breaker half-open, got %s", s)
+	}
+	if _, err := l.Load(ctx, "other"); !errors.Is(err, loader.ErrBreakerOpen) {
+		t.Fatalf("expected the trial slot to stay taken, got %v", err)
+	}
+
+	// The trial call alone decides
+	close(store.gates["probe"])
+	if err := <-probe; err != nil {
+		t.Fatalf("expected the trial call to succeed, got %v", err)
+	}
+	if s := breaker.State(); s != types.BreakerClosed {
+		t.Fatalf("expected the trial call to close the breaker, got %s", s)
+	}
+}
+
+func TestGetOrLoadUsesPerCallLoaderAndTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	store := NewTestStore()
+	store.data["user:1"] = "from engine loader"
+
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterWrite{TTL: time.Hour}, nil, store, nil, nil)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine)
+	defer c.Close()
+
+	var calls atomic.Int64
+	load := func(ctx context.Context) (any, time.Duration, error) {
+		calls.Add(1)
+		return "from profile service", 5 * time.Second, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if v, err := c.GetOrLoad(ctx, "user:1", load); v != "from profile service" || err != nil {
+			t.Fatalf("expected value from the per-call loader, got %v, %v", v, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected one load, got %d", n)
+	}
+
+	// The TTL returned by the loader applies
+	if ttl := c.TTL("user:1"); ttl <= 0 || ttl > 5*time.Second {
+		t.Fatalf("expected TTL from the loader, got %v", ttl)
+	}
+	clock.Advance(6 * time.Second)
+	c.GetOrLoad(ctx, "user:1", load)
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected a reload after the loader's TTL, got %d loads", n)
+	}
+}
+
+func TestRefreshUsesThePerCallLoader(t *testing.T) {
+	ctx := context.Background()
+	clock := cachetest.NewFakeClock(time.Now())
+	loader := &countingLoader{TestStore: NewTestStore()}
+	loader.data["user:1"] = "from engine loader"
+
+	hook := refresh.NewRefreshAfterWrite(time.Minute, 4)
+	engine := engine.NewCacheEngine(&expiration.ExpireAfterWrite{TTL: time.Hour}, hook, loader, nil, nil)
+	engine.Clock = clock
+	c := cache.NewShardedCache(1, 10, eviction.LRU, engine)
+
+	var calls atomic.Int64
+	c.GetOrLoad(ctx, "user:1", func(ctx context.Context) (any, time.Duration, error) {
+		n := calls.Add(1)
+		return fmt.Sprintf("profile v%d", n), 10 * time.Minute, nil
+	})
+
+	// The refresh goes back to the profile service, and its new TTL applies
+	clock.Advance(2 * time.Minute)
+	c.Get(ctx, "user:1")
+	c.Close()
+
+	if v, _ := c.GetOrLoad(ctx, "user:1", nil); v != "profile v2" {
+		t.Fatalf("expected the value refreshed by the per-call loader, got %v", v)
+	}
+	if n := loader.loads.Load(); n != 0 {
+		t.Fatalf("expected the engine loader to be left alone, got %d loads", n)
+	}
+	if ttl := c.TTL("user:1"); ttl <= 8*time.Minute {
+		t.Fatalf("expected the TTL returned on refresh, got %v", ttl)
+	}
+}
+
+func TestLoadBatchingGroupsConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	loader := &batchLoader{TestStore: NewTestStore()}
+	for i := 0; i < 20; i++ {
+		loader.data[fmt.Sprintf("key-%d", i)] = i
+	}
+
+	engine := engine.NewCacheEngine(nil, nil, loader, nil, nil)
+	c := cache.NewShardedCache(4, 100, eviction.LRU, engine, cache.WithLoadBatching(time.Second, 10))
+	defer c.Close()
+
+	// 40 callers, 2 per key: duplicates share a load, distinct keys share a batch.
+	// Batches are held until every caller is in, so no duplicate arrives after its key's load.
+	loader.gate = make(chan struct{})
+	var wg, started sync.WaitGroup
+	started.Add(40)
+	go func() {
+		started.Wait()
+		time.Sleep(20 * time.Millisecond)
+		close(loader.gate)
+	}()
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			started.Done()
+			key := fmt.Sprintf("key-%d", i%20)
+			if v, err := c.Get(ctx, key); v != i%20 || err != nil {
+				t.Errorf("expected %s=%d, got %v, %v", key, i%20, v, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(loader.batches) != 2 || len(loader.batches[0]) != 10 || len(loader.batches[1]) != 10 {
+		t.Fatalf("expected two full batches of 10 keys, got %v", loader.batches)
+	}
+
+	// A lone miss is sent when the window closes
+	loader.data["late"] = "value"
+	c2 := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithLoadBatching(5*time.Millisecond, 10))
+	defer c2.Close()
+	if v, _ := c2.Get(ctx, "late"); v != "value" {
+		t.Fatalf("expected value after the batching window, got %v", v)
+	}
+
+	// A batch abandoned by all its callers does not fail the callers that come after
+	c3 := cache.NewShardedCache(1, 10, eviction.LRU, engine, cache.WithLoadBatching(50*time.Millisecond, 10))
+	defer c3.Close()
+	cancelled, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(time.Millisecond)
+		cancel()
+	}()
+	if _, err := c3.Get(cancelled, "key-1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the first caller to be cancelled, got %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if v, err := c3.Get(ctx, "key-2"); v != 2 || err != nil {
+		t.Fatalf("expected key-2=2 from a fresh batch, got %v, %v", v, err)
+	}
+}
+
+// badKeyLoader is a TestStore whose loads of one key always fail.
+type badKeyLoader struct {
+	*TestStore
+	bad string
+}
+
+func (l *badKeyLoader) Load(ctx context.Context, key string) (any, error) {
+	if key == l.bad {
+		return nil, errors.New("bad key")
+	}
+	return l.TestStore.Load(ctx, key)
+}
+
+// badKeyBatchLoader loads whole batches, reporting the bad key in a types.KeyErrors.
+type badKeyBatchLoader struct {
+	*badKeyLoader
+}
+
+func (l *badKeyBatchLoader) LoadAll(ctx context.Context, keys []string) (map[string]any, error) {
+	vals := make(map[string]any)
+	errs := make(types.KeyErrors)
+	for _, key := range keys {
+		if key == l.bad {
+			errs[key] = errors.New("bad key")
+		} else if v := l.data[key]; v != nil {
+			vals[key] = v
+		}
+	}
+	return vals, errs
+}
+
+func TestBatchLoadFailsOnlyTheFailedKeys(t *testing.T) {
+	ctx := context.Background()
+	plain := &badKeyLoader{TestStore: NewTestStore(), bad: "key-0"}
+	for i := 1; i < 6; i++ {
+		plain.data[fmt.Sprintf("key-%d", i)] = i
+	}
+
+	for name, l := range map[string]types.Loader{
+		"one load per key": plain,
+		"batch loader":     &badKeyBatchLoader{plain},
+	} {
+		// Six concurrent misses fill one batch; only the bad key fails
+		e := engine.NewCacheEngine(nil, nil, l, nil, nil)
+		c := cache.NewShardedCache(2, 100, eviction.LRU, e, cache.WithLoadBatching(time.Second, 6))
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				v, err := c.Get(ctx, fmt.Sprintf("key-%d", i))
+				if i == 0 && (v != nil || err == nil) {
+					t.Errorf("%s: expected the bad key to fail, got %v, %v", name, v, err)
+				}
+				if i > 0 && (v != i || err != nil) {
+					t.Errorf("%s: expected key-%d=%d, got %v, %v", name, i, i, v, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		c.Close()
+
+		// GetAll keeps every key that loaded, and reports the failure
+		c = cache.NewShardedCache(2, 100, eviction.LRU, e)
+		vals, err := c.GetAll(ctx, []string{"key-0", "key-1", "key-2", "missing"})
+		if err == nil || len(vals) != 2 || vals["key-1"] != 1 || vals["key-2"] != 2 {
+			t.Errorf("%s: expected key-1 and key-2 with an error, got %v, %v", name, vals, err)
+		}
+		c.Close()
+	}
+}
+
+// batchStore is a TestStore that records every PutAll batch.
+type batchStore struct {
+	*TestStore
+	mu      sync.Mutex
+	batches []map[string]any
+}
+
+func (s *batchStore) PutAll(ctx context.Context, values map[string]any) error {
+	s.mu.Lock()
+	s.batches = append(s.batches, values)
+	s.mu.Unlock()
+
+	for key, value := range values {
+		s.TestStore.Put(ctx, key, value)
+	}
+	return nil
+}
+
+func (s *batchStore) batchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+func TestWriteBackCoalescesAndBatches(t *testing.T) {
+	ctx := context.Background()
+
+	// A hot key written many times between flushes costs one write
+	store := &batchStore{TestStore: NewTestStore()}
+	wb := writepolicy.NewWriteBackPolicy(store, 16, writepolicy.WithFlushInterval(time.Hour))
+	for i := 0; i < 10000; i++ {
+		wb.OnWrite(ctx, "counter", i)
+	}
+	wb.OnWrite(ctx, "a", "alpha")
+	wb.OnWrite(ctx, "b", "beta")
+	wb.Close()
+
+	if len(store.batches) != 1 || len(store.batches[0]) != 3 {
+		t.Fatalf("expected one batch of 3 keys, got %v", store.batches)
+	}
+	if v := store.data["counter"]; v != 9999 {
+		t.Fatalf("expected last write to win, got %v", v)
+	}
+
+	// A full batch is flushed without waiting for the interval
+	store = &batchStore{TestStore: NewTestStore()}
+	wb = writepolicy.NewWriteBackPolicy(store, 16, writepolicy.WithFlushInterval(time.Hour), writepolicy.WithBatchSize(2))
+	defer wb.Close()
+	for i := 0; i < 4; i++ {
+		wb.OnWrite(ctx, fmt.Sprintf("key-%d", i), i)
+	}
+	waitFor(t, "two full batches", func() bool { return store.batchCount() == 2 })
+}
+
+// downStore is a TestStore whose Put fails while down is set, or for the next `failures` calls.
+type downStore struct {
+	*TestStore
+	down     atomic.Bool
+	failures atomic.Int64
+}
+
+func (s *downStore) Put(ctx context.Context, key string, value any) error {
+	if s.down.Load() || s.failures.Add(-1) >= 0 {
+		return errors.New("backing store down")
+	}
+	return s.TestStore.Put(ctx, key, value)
+}
+
+// crashCopy copies the log in dir as a crash would leave it, while its policy keeps running.
+func crashCopy(t *testing.T, dir string) string {
+	t.Helper()
+	crashed := t.TempDir()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatalf("read segment: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(crashed, f.Name()), data, 0o644); err != nil {
+			t.Fatalf("copy segment: %v", err)
+		}
+	}
+	return crashed
+}
+
+func TestDurableWriteBackReplaysUndeliveredWrites(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Writes wait for the next flush when the process crashes
+	wb, err := writepolicy.NewDurableWriteBackPolicy(NewTestStore(), 16, dir, writepolicy.SyncAlways,
+		writepolicy.WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	wb.OnWrite(ctx, "a", "alpha")
+	wb.OnWrite(ctx, "b", "beta")
+	wb.OnWrite(ctx, "a", "alpha-2")
+	crashed := crashCopy(t, dir)
+	wb.Close()
+
+	// Restart from what the crash left: pending writes are replayed
+	store := NewTestStore()
+	wb, err = writepolicy.NewDurableWriteBackPolicy(store, 16, crashed, writepolicy.SyncAlways)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	waitFor(t, "replayed writes", func() bool {
+		v, _ := store.Load(ctx, "b")
+		return v == "beta"
+	})
+	wb.Close()
+
+	if v := store.data["a"]; v != "alpha-2" {
+		t.Fatalf("expected the latest value of a, got %v", v)
+	}
+
+	// Everything was acknowledged, so the log is truncated
+	files, _ := os.ReadDir(crashed)
+	if len(files) != 0 {
+		t.Fatalf("expected an empty log after delivery, got %d files", len(files))
+	}
+}
+
+func TestDurableWriteBackDoesNotReplayFinishedWrites(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	open := func(store types.Loader) *writepolicy.WriteBackPolicy {
+		wb, err := writepolicy.NewDurableWriteBackPolicy(store, 16, dir, writepolicy.SyncAlways)
+		if err != nil {
+			t.Fatalf("open failed: %v", err)
+		}
+		return wb
+	}
+
+	// A write that failed for good is reported, then leaves the log
+	var reported atomic.Int64
+	store := &downStore{TestStore: NewTestStore()}
+	store.down.Store(true)
+	wb, err := writepolicy.NewDurableWriteBackPolicy(store, 16, dir, writepolicy.SyncAlways,
+		writepolicy.WithOnError(func(key string, value any, err error) { reported.Add(1) }))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	wb.OnWrite(ctx, "k", "v1")
+	wb.Close()
+	if files, _ := os.ReadDir(dir); reported.Load() != 1 || len(files) != 0 {
+		t.Fatalf("expected the failed write reported and dropped from the log, got %d reports, %d files", reported.Load(), len(files))
+	}
+
+	// It does not come back over a newer value on later restarts
+	store.down.Store(false)
+	wb = open(store)
+	wb.OnWrite(ctx, "k", "v2")
+	wb.Close()
+	open(store).Close()
+	if v := store.data["k"]; v != "v2" {
+		t.Fatalf("expected v2 to stay, got %v", v)
+	}
+
+	// A delivered write is not replayed, even if its segment is still live when the process crashes
+	gated := &gatedStore{TestStore: NewTestStore(), entered: make(chan struct{}, 1), gate: make(chan struct{})}
+	wb = open(gated)
+	wb.OnWrite(ctx, "a", "alpha")
+	<-gated.entered
+	gated.gate <- struct{}{}
+	wb.OnWrite(ctx, "b", "beta")
+	<-gated.entered // a is acknowledged before the next batch starts
+	crashed := crashCopy(t, dir)
+	close(gated.gate)
+	wb.Close()
+
+	replay := &gatedStore{TestStore: NewTestStore(), entered: make(chan struct{}, 1), gate: make(chan struct{})}
+	close(replay.gate)
+	wb, err = writepolicy.NewDurableWriteBackPolicy(replay, 16, crashed, writepolicy.SyncAlways)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	wb.Close()
+	if len(replay.writes) != 1 || replay.writes[0] != "b" {
+		t.Fatalf("expected only b replayed, got %v", replay.writes)
+	}
+}
+
+// unregisteredValue is a value type that was never registered with gob.
+type unregisteredValue struct{ N int }
+
+func TestDurableWriteBackFailsWritesItCannotLog(t *testing.T) {
+	ctx := context.Background()
+	store := NewTestStore()
+	metrics := &writeMetrics{}
+
+	var reported error
+	wb, err := writepolicy.NewDurableWriteBackPolicy(store, 16, t.TempDir(), writepolicy.SyncAlways,
+		writepolicy.WithMetrics(metrics),
+		writepolicy.WithOnError(func(key string, value any, err error) { reported = err }),
+	)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	wb.OnWrite(ctx, "custom", unregisteredValue{1})
+	wb.OnWrite(ctx, "plain", "value")
+	wb.Close()
+
+	if !errors.Is(reported, writepolicy.ErrNotDurable) || metrics.failed.Load() != 1 {
+		t.Fatalf("expected the unloggable write to be reported as failed, got %v", reported)
+	}
+	if _, ok := store.data["custom"]; ok || store.data["plain"] != "value" {
+		t.Fatalf("expected only the logged write delivered, got %v", store.data)
+	}
+}
+
+// writeMetrics counts write failures.
+type writeMetrics struct {
+	types.NoopMetrics
+	retried, failed, deadLettered atomic.Int64
+}
+
+func (m *writeMetrics) WriteRetried()      { m.retried.Add(1) }
+func (m *writeMetrics) WriteFailed()       { m.failed.Add(1) }
+func (m *writeMetrics) WriteDeadLettered() { m.deadLettered.Add(1) }
+
+func TestWriteFailuresAreRetriedAndDeadLettered(t *testing.T) {
+	ctx := context.Background()
+	store := &downStore{TestStore: NewTestStore()}
+	metrics := &writeMetrics{}
+
+	var mu sync.Mutex
+	var errored, dead []string
+	opts := []writepolicy.Option{
+		writepolicy.WithRetry(3, loader.Backoff{Initial: time.Millisecond}),
+		writepolicy.WithMetrics(metrics),
+		writepolicy.WithOnError(func(key string, value any, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errored = append(errored, key)
+		}),
+		writepolicy.WithDeadLetter(writepolicy.DeadLetterFunc(func(ctx context.Context, key string, value any, err error) error {
+			mu.Lock()
+			defer mu.Unlock()
+			dead = append(dead, key)
+			return nil
+		})),
+	}
+
+	// Write-through: two transient failures are retried away
+	wt := writepolicy.NewWriteThroughPolicy(store, opts...)
+	store.failures.Store(2)
+	wt.OnWrite(ctx, "flaky", "value")
+	if store.data["flaky"] != "value" || metrics.retried.Load() != 2 || metrics.failed.Load() != 0 {
+		t.Fatalf("expected success after 2 retries, got %v retries, %v failures", metrics.retried.Load(), metrics.failed.Load())
+	}
+
+	// Write-through: a write that keeps failing is dead-lettered
+	store.down.Store(true)
+	wt.OnWrite(ctx, "lost", "value")
+
+	// Write-back: same on the background worker
+	wb := writepolicy.NewWriteBackPolicy(store, 16, opts...)
+	wb.OnWrite(ctx, "lost-async", "value")
+	wb.Close()
+
+	if fmt.Sprint(errored) != "[lost lost-async]" || fmt.Sprint(dead) != "[lost lost-async]" {
+		t.Fatalf("expected both failed writes reported and dead-lettered, got %v and %v", errored, dead)
+	}
+	if metrics.failed.Load() != 2 || metrics.deadLettered.Load() != 2 || metrics.retried.Load() != 6 {
+		t.Fatalf("unexpected metrics: retried=%d failed=%d dead=%d",
+			metrics.retried.Load(), metrics.failed.Load(), metrics.deadLettered.Load())
+	}
+}
+
+// gatedStore holds every Put until the gate is closed, and records the order of writes.
+type gatedStore struct {
+	*TestStore
+	entered chan struct{}
+	gate    chan struct{}
+
+	mu     sync.Mutex
+	writes []string
+}
+
+func (s *gatedStore) Put(ctx context.Context, key string, value any) error {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.gate
+
+	s.mu.Lock()
+	s.writes = append(s.writes, key)
+	s.mu.Unlock()
+	return s.TestStore.Put(ctx, key, value)
+}
+
+// overflowMetrics counts write-back overflow events and tracks the deepest queue seen.
+type overflowMetrics struct {
+	types.NoopMetrics
+	dropped, blocked, spilled, maxDepth atomic.Int64
+}
+
+func (m *overflowMetrics) WriteDropped() { m.dropped.Add(1) }
+func (m *overflowMetrics) WriteBlocked() { m.blocked.Add(1) }
+func (m *overflowMetrics) WriteSpilled() { m.spilled.Add(1) }
+func (m *overflowMetrics) WriteQueueDepth(depth int) {
+	for {
+		cur := m.maxDepth.Load()
+		if int64(depth) <= cur || m.maxDepth.CompareAndSwap(cur, int64(depth)) {
+			return
+		}
+	}
+}
+
+func TestWriteBackOverflowModes(t *testing.T) {
+	tests := []struct {
+		mode     writepolicy.OverflowMode
+		writes   []string
+		dropped  int64
+		blocked  int64
+		spilled  int64
+		maxDepth int64
+	}{
+		{writepolicy.OverflowDropNewest, []string{"k0", "k1", "k2"}, 2, 0, 0, 2},
+		{writepolicy.OverflowDropOldest, []string{"k0", "k3", "k4"}, 2, 0, 0, 2},
+		{writepolicy.OverflowBlock, []string{"k0", "k1", "k2"}, 2, 2, 0, 2},
+		{writepolicy.OverflowSpill, []string{"k0", "k1", "k2", "k3", "k4"}, 0, 0, 2, 4},
+	}
+
+	for _, tt := range tests {
+		store := &gatedStore{TestStore: NewTestStore(), entered: make(chan struct{}, 1), gate: make(chan struct{})}
+		metrics := &overflowMetrics{}
+		wb := writepolicy.NewWriteBackPolicy(store, 2,
+			writepolicy.WithBatchSize(1),
+			writepolicy.WithOverflow(tt.mode),
+			writepolicy.WithSpillDir(t.TempDir()),
+			writepolicy.WithMetrics(metrics),
+		)
+
+		// k0 is taken by the worker, which then hangs in Put; k1 and k2 fill the queue
+		wb.OnWrite(context.Background(), "k0", 0)
+		<-store.entered
+		for i := 1; i < 5; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+			wb.OnWrite(ctx, fmt.Sprintf("k%d", i), i)
+			cancel()
+		}
+
+		close(store.gate)
+		wb.Close()
+
+		if fmt.Sprint(store.writes) != fmt.Sprint(tt.writes) {
+			t.Fatalf("mode %d: expected writes %v, got %v", tt.mode, tt.writes, store.writes)
+		}
+		if metrics.dropped.Load() != tt.dropped || metrics.blocked.Load() != tt.blocked ||
+			metrics.spilled.Load() != tt.spilled || metrics.maxDepth.Load() != tt.maxDepth {
+			t.Fatalf("mode %d: unexpected metrics dropped=%d blocked=%d spilled=%d maxDepth=%d", tt.mode,
+				metrics.dropped.Load(), metrics.blocked.Load(), metrics.spilled.Load(), metrics.maxDepth.Load())
+		}
+	}
+}
+
+// deleteStore is a batchStore that can also delete keys (types.Deleter).
+type deleteStore struct {
+	*batchStore
+	deletes atomic.Int64
+}
+
+func (s *deleteStore) Delete(ctx context.Context, key string) error {
+	s.deletes.Add(1)
+	s.TestStore.Delete(key)
+	return nil
+}
+
+func TestRemovePropagatesToBackingStore(t *testing.T) {
+	ctx := context.Background()
+
+	// Write-through: the key is gone from the backing store before Remove returns
+	store := &deleteStore{batchStore: &batchStore{TestStore: NewTestStore()}}
+	e := engine.NewCacheEngine(nil, nil, store, writepolicy.NewWriteThroughPolicy(store), nil)
+	c := cache.NewShardedCache(2, 10, eviction.LRU, e)
+	defer c.Close()
+
+	c.Put(ctx, "key1", "value1")
+	c.Remove("key1")
+	if _, ok := store.data["key1"]; ok {
+		t.Fatalf("expected key1 deleted from the backing store")
+	}
+	if v, _ := c.Get(ctx, "key1"); v != nil {
+		t.Fatalf("expected nil after remove, got %v", v)
+	}
+
+	// Write-back: tombstones coalesce with writes in order, the last operation wins
+	store = &deleteStore{batchStore: &batchStore{TestStore: NewTestStore()}}
+	store.data["a"] = "old"
+	wb := writepolicy.NewWriteBackPolicy(store, 16, writepolicy.WithFlushInterval(time.Hour))
+	wb.OnWrite(ctx, "a", "alpha")
+	wb.OnDelete(ctx, "a")
+	wb.OnDelete(ctx, "b")
+	wb.OnWrite(ctx, "b", "beta")
+	wb.Close()
+
+	if _, ok := store.data["a"]; ok {
+		t.Fatalf("expected a deleted, got %v", store.data["a"])
+	}
+	if store.data["b"] != "beta" {
+		t.Fatalf("expected b written after its delete, got %v", store.data["b"])
+	}
+	if store.deletes.Load() != 1 || store.batchCount() != 1 || len(store.batches[0]) != 1 {
+		t.Fatalf("expected one delete and one batch of one write, got %d deletes and %v", store.deletes.Load(), store.batches)
+	}
+
+	// Without types.Deleter, Remove leaves the backing store alone
+	c2, plain := newTestCache(10)
+	c2.Put(ctx, "key1", "value1")
+	waitFor(t, "write-back of key1", func() bool { v, _ := plain.Load(ctx, "key1"); return v != nil })
+	c2.Remove("key1")
+	if v, _ := plain.Load(ctx, "key1"); v != "value1" {
+		t.Fatalf("expected backing store untouched, got %v", v)
+	}
+}
+
+// hangingDeleter is a TestStore whose deletes hang until their ctx is done.
+type hangingDeleter struct {
+	*TestStore
+}
+
+func (s *hangingDeleter) Delete(ctx context.Context, key string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRemoveContextBoundsTheBackingStore(t *testing.T) {
+	store := &hangingDeleter{NewTestStore()}
+	var reported atomic.Int64
+	wt := writepolicy.NewWriteThroughPolicy(store, writepolicy.WithOnError(func(key string, value any, err error) { reported.Add(1) }))
+	e := engine.NewCacheEngine(nil, nil, store, wt, nil)
+	c := cache.NewShardedCache(1, 10, eviction.LRU, e)
+	defer c.Close()
+
+	// A TTL makes the entry visible to TTL (-1 means missing or no TTL)
+	c.PutWithTTL(context.Background(), "key", "value", time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.RemoveContext(ctx, "key")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected RemoveContext to give up with its ctx")
+	}
+	if c.TTL("key") != -1 || reported.Load() != 1 {
+		t.Fatalf("expected the key gone from the cache and the failed delete reported, got %v, %d reports", c.TTL("key"), reported.Load())
+	}
+}
+
+func TestWriteBackServesUndeliveredWrites(t *testing.T) {
+	ctx := context.Background()
+	store := &deleteStore{batchStore: &batchStore{TestStore: NewTestStore()}}
+	store.data["row"] = "from store"
+	store.data["old"] = "from store"
+
+	wb := writepolicy.NewWriteBackPolicy(store, 16, writepolicy.WithFlushInterval(time.Hour))
+	e := engine.NewCacheEngine(nil, nil, store, wb, nil)
+	c := cache.NewShardedCache(1, 1, eviction.LRU, e)
+
+	if v, _ := c.Get(ctx, "row"); v != "from store" {
+		t.Fatalf("expected row loaded from the store, got %v", v)
+	}
+
+	// The tombstone is still queued: Get and GetAll must not load the row back
+	c.Remove("row")
+	if v, _ := c.Get(ctx, "row"); v != nil {
+		t.Fatalf("expected removed row to stay missing, got %v", v)
+	}
+	if vals, _ := c.GetAll(ctx, []string{"row"}); len(vals) != 0 {
+		t.Fatalf("expected removed row to stay missing from GetAll, got %v", vals)
+	}
+
+	// A queued write evicted from memory is served as written, not as the store has it
+	c.Put(ctx, "old", "new")
+	c.Put(ctx, "other", "value")
+	if v, _ := c.Get(ctx, "old"); v != "new" {
+		t.Fatalf("expected the undelivered write, got %v", v)
+	}
+
+	c.Close()
+	if _, ok := store.data["row"]; ok {
+		t.Fatalf("expected row deleted from the backing store on flush")
+	}
+	if store.data["old"] != "new" {
+		t.Fatalf("expected old written on flush, got %v", store.data["old"])
+	}
+
+	// Spilled writes are pending too, newest first
+	spill := writepolicy.NewWriteBackPolicy(store, 1, writepolicy.WithFlushInterval(time.Hour),
+		writepolicy.WithOverflow(writepolicy.OverflowSpill), writepolicy.WithSpillDir(t.TempDir()))
+	defer spill.Close()
+	spill.OnWrite(ctx, "a", "queued")
+	spill.OnWrite(ctx, "b", "spilled")
+	spill.OnDelete(ctx, "b")
+	if _, deleted, ok := spill.Pending("b"); !ok || !deleted {
+		t.Fatalf("expected the spilled tombstone of b, got deleted=%v ok=%v", deleted, ok)
+	}
+	if v, _, _ := spill.Pending("a"); v != "queued" {
+		t.Fatalf("expected the queued write of a, got %v", v)
+	}
+}
+
+// slowStore is a TestStore whose writes take a random, short time.
+type slowStore struct {
+	*TestStore
+}
+
+func (s *slowStore) Put(ctx context.Context, key string, value any) error {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	return s.TestStore.Put(ctx, key, value)
+}
+
+func TestWritesReachTheWritePolicyInOrder(t *testing.T) {
+	ctx := context.Background()
+	store := &slowStore{NewTestStore()}
+	e := engine.NewCacheEngine(nil, nil, store, writepolicy.NewWriteThroughPolicy(store), nil)
+	c := cache.NewShardedCache(2, 10, eviction.LRU, e)
+	defer c.Close()
+
+	// Racing writes of one key: the backing store must end with the value the cache kept
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				c.Put(ctx, "key", fmt.Sprintf("%d-%d", g, i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	cached, _ := c.Get(ctx, "key")
+	if stored, _ := store.Load(ctx, "key"); stored != cached {
+		t.Fatalf("expected the backing store to match the cache, got %v and %v", stored, cached)
+	}
+}
+
+func TestBlockedWriteBackDoesNotStallTheShard(t *testing.T) {
+	ctx := context.Background()
+	store := &gatedStore{TestStore: NewTestStore(), entered: make(chan struct{}, 1), gate: make(chan struct{})}
+	metrics := &overflowMetrics{}
+	wb := writepolicy.NewWriteBackPolicy(store, 1,
+		writepolicy.WithBatchSize(1),
+		writepolicy.WithOverflow(writepolicy.OverflowBlock),
+		writepolicy.WithMetrics(metrics),
+	)
+	e := engine.NewCacheEngine(nil, nil, store, wb, nil)
+	c := cache.NewShardedCache(1, 10, eviction.LRU, e)
+
+	// k0 hangs in the backing store, k1 fills the queue, k2 blocks waiting for room
+	c.Put(ctx, "k0", 0)
+	<-store.entered
+	c.Put(ctx, "k1", 1)
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		c.Put(ctx, "k2", 2)
+	}()
+	waitFor(t, "k2 to block", func() bool { return metrics.blocked.Load() == 1 })
+
+	// The shard stays usable for everyone else meanwhile
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.PutWithTTL(ctx, "local", "v", time.Minute)
+		c.Remove("k0")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the shard to stay unlocked while a write-back writer blocks")
+	}
+
+	close(store.gate)
+	<-blocked
+	c.Close()
+	if v, _ := store.Load(ctx, "k2"); v != 2 {
+		t.Fatalf("expected the blocked write to be delivered, got %v", v)
+	}
+}
+
+// flakyDeleter is a TestStore that can delete keys, failing the first `failures` deletes.
+type flakyDeleter struct {
+	*TestStore
+	failures atomic.Int64
+}
+
+func (s *flakyDeleter) Delete(ctx context.Context, key string) error {
+	if s.failures.Add(-1) >= 0 {
+		return errors.New("backing store down")
+	}
+	s.TestStore.Delete(key)
+	return nil
+}
+
+func TestLoaderMiddlewareKeepsOptionalCapabilities(t *testing.T) {
+	ctx := context.Background()
+	breaker := loader.NewCircuitBreaker("db", 5, time.Minute)
+	chain := func(l types.Loader) types.Loader {
+		return loader.Chain(l, loader.Retry(3, loader.Backoff{Initial: time.Millisecond}), breaker.Middleware, loader.Timeout(time.Second))
+	}
+
+	wrapped := chain(&deleteStore{batchStore: &batchStore{TestStore: NewTestStore()}})
+	if _, ok := wrapped.(types.Deleter); !ok {
+		t.Fatalf("expected the wrapped loader to keep types.Deleter")
+	}
+	if _, ok := wrapped.(types.BatchPutter); !ok {
+		t.Fatalf("expected the wrapped loader to keep types.BatchPutter")
+	}
+	if _, ok := wrapped.(types.BatchLoader); ok {
+		t.Fatalf("expected no types.BatchLoader on a loader that does not have it")
+	}
+
+	// Remove reaches the backing store through the chain, with retries
+	store := &flakyDeleter{TestStore: NewTestStore()}
+	store.failures.Store(2)
+	l := chain(store)
+	e := engine.NewCacheEngine(nil, nil, l, writepolicy.NewWriteThroughPolicy(l), nil)
+	c := cache.NewShardedCache(1, 10, eviction.LRU, e)
+	defer c.Close()
+
+	c.Put(ctx, "key", "value")
+	c.Remove("key")
+	if v, _ := store.Load(ctx, "key"); v != nil {
+		t.Fatalf("expected the delete to be retried through the chain, got %v", v)
+	}
+}
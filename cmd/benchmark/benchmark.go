@@ -10,6 +10,7 @@ import (
 	"github.com/krisalay/in-memory-cache/engine"
 	"github.com/krisalay/in-memory-cache/eviction"
 	"github.com/krisalay/in-memory-cache/expiration"
+	"github.com/krisalay/in-memory-cache/shard"
 	"github.com/krisalay/in-memory-cache/writepolicy"
 )
 
@@ -39,19 +40,26 @@ func (s *InMemoryStore) Put(ctx context.Context, key string, value any) error {
 
 // ================= BENCHMARK =================
 
-func main() {
-	ctx := context.Background()
+const (
+	shards      = 8
+	capacity    = 200000
+	preloadKeys = 100000
+	goroutines  = 200
+	opsPerG     = 5000
+	writesPerG  = 100
+)
 
-	fmt.Println("\n================ CACHE LOAD BENCHMARK =================")
+// result holds the measurements of one benchmark run.
+type result struct {
+	preload    time.Duration
+	reads      time.Duration
+	writes     time.Duration
+	totalReads int
+	totalPuts  int
+}
 
-	// ---------------- Cache Config ----------------
-	const (
-		shards      = 8
-		capacity    = 200000
-		preloadKeys = 100000
-		goroutines  = 200
-		opsPerG     = 5000
-	)
+func main() {
+	fmt.Println("\n================ CACHE LOAD BENCHMARK =================")
 
 	fmt.Println("CONFIG")
 	fmt.Println("---------------------------------")
@@ -60,8 +68,36 @@ func main() {
 	fmt.Println("Preload Keys :", preloadKeys)
 	fmt.Println("Goroutines   :", goroutines)
 	fmt.Println("Ops/Goroutine:", opsPerG)
+	fmt.Println("Puts/Goroutine:", writesPerG)
 	fmt.Println("---------------------------------")
 
+	stores := []shard.StoreType{shard.COW, shard.Mutable}
+	results := make(map[shard.StoreType]result, len(stores))
+
+	for _, st := range stores {
+		fmt.Printf("\nRunning benchmark with %s store...\n", st)
+		results[st] = run(st)
+	}
+
+	fmt.Println("\n================ RESULTS =================")
+	for _, st := range stores {
+		r := results[st]
+		fmt.Printf("\n[%s store]\n", st)
+		fmt.Printf("Preload Time     : %v\n", r.preload)
+		fmt.Printf("Read Operations  : %d\n", r.totalReads)
+		fmt.Printf("Read Time        : %v\n", r.reads)
+		fmt.Printf("Read Throughput  : %.2f ops/sec\n", float64(r.totalReads)/r.reads.Seconds())
+		fmt.Printf("Write Operations : %d\n", r.totalPuts)
+		fmt.Printf("Write Time       : %v\n", r.writes)
+		fmt.Printf("Write Throughput : %.2f ops/sec\n", float64(r.totalPuts)/r.writes.Seconds())
+	}
+	fmt.Println("=========================================")
+}
+
+// run executes the preload, read and write phases against a cache using the given store type.
+func run(st shard.StoreType) result {
+	ctx := context.Background()
+
 	// ---------------- Backing Store ----------------
 	store := NewInMemoryStore()
 
@@ -82,70 +118,56 @@ func main() {
 		capacity,
 		eviction.LRU,
 		engine,
+		cache.WithStore(st),
 	)
+	defer c.Close()
+
+	var r result
 
 	// ---------------- Preload Cache ----------------
-	fmt.Println("Preloading cache...")
+	start := time.Now()
 	for i := 0; i < preloadKeys; i++ {
-		key := fmt.Sprintf("key-%d", i)
-		c.Put(ctx, key, i)
+		c.Put(ctx, fmt.Sprintf("key-%d", i), i)
 	}
-	fmt.Println("Preload complete.")
+	r.preload = time.Since(start)
 
 	// ---------------- Warmup ----------------
-	fmt.Println("Warming up cache...")
 	for i := 0; i < 10000; i++ {
 		c.Get(ctx, fmt.Sprintf("key-%d", i%preloadKeys))
 	}
-	fmt.Println("Warmup complete.")
 
-	// ---------------- Load Test ----------------
-	fmt.Println("Running concurrency benchmark...")
+	// ---------------- Read Load ----------------
+	r.totalReads = goroutines * opsPerG
+	r.reads = parallel(func(id int) {
+		for j := 0; j < opsPerG; j++ {
+			c.Get(ctx, fmt.Sprintf("key-%d", j%preloadKeys))
+		}
+	})
+
+	// ---------------- Write Load ----------------
+	r.totalPuts = goroutines * writesPerG
+	r.writes = parallel(func(id int) {
+		for j := 0; j < writesPerG; j++ {
+			c.Put(ctx, fmt.Sprintf("key-%d", (id*writesPerG+j)%preloadKeys), j)
+		}
+	})
+
+	return r
+}
 
+// parallel runs fn on all goroutines and returns how long it took.
+func parallel(fn func(id int)) time.Duration {
 	start := time.Now()
 
 	wg := sync.WaitGroup{}
 	wg.Add(goroutines)
-
 	for i := 0; i < goroutines; i++ {
 		go func(id int) {
 			defer wg.Done()
-			for j := 0; j < opsPerG; j++ {
-				key := fmt.Sprintf("key-%d", j%preloadKeys)
-				c.Get(ctx, key)
-			}
+			fn(id)
 		}(i)
 	}
-
 	wg.Wait()
 
-	duration := time.Since(start)
-	totalOps := goroutines * opsPerG
-
-	fmt.Println("\n================ CACHE LOAD BENCHMARK =================")
-
-	fmt.Println("CONFIG")
-	fmt.Println("---------------------------------")
-	fmt.Println("Shards       :", shards)
-	fmt.Println("Capacity     :", capacity)
-	fmt.Println("Preload Keys :", preloadKeys)
-	fmt.Println("Goroutines   :", goroutines)
-	fmt.Println("Ops/Goroutine:", opsPerG)
-	fmt.Println("---------------------------------")
-
-	fmt.Println("\nPreloading cache...")
-	fmt.Println("Preload complete.")
-
-	fmt.Println("\nWarming up cache...")
-	fmt.Println("Warmup complete.")
-
-	fmt.Println("\nRunning concurrency benchmark...")
-
-	fmt.Println("\n================ RESULTS =================")
-	fmt.Printf("Total Operations : %d\n", totalOps)
-	fmt.Printf("Total Time       : %v\n", duration)
-	fmt.Printf("Throughput       : %.2f ops/sec\n", float64(totalOps)/duration.Seconds())
-	fmt.Println("=========================================")
-
-	c.Close()
+	return time.Since(start)
 }
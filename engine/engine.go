@@ -58,6 +58,10 @@ type CacheEngine struct {
 	// Metrics is how we keep track of what the cache is doing.
 	// Hits, misses, evictions, expirations, refreshes, etc.
 	Metrics types.Metrics
+
+	// Clock is where every TTL decision gets the current time from.
+	// It defaults to the system clock; tests can swap in a fake clock.
+	Clock types.Clock
 }
 
 /*
@@ -83,21 +87,27 @@ func NewCacheEngine(
 		Loader:      loader,
 		WritePolicy: writePolicy,
 		Metrics:     metrics,
+		Clock:       types.SystemClock{},
 	}
 }
 
+// Now returns the current time according to the engine's Clock.
+func (e *CacheEngine) Now() time.Time {
+	return e.Clock.Now()
+}
+
 /*
 IsExpired checks whether a cache entry is expired.
 
 BEHAVIOR:
 ---------
 - Delegates the decision to the configured Expiration strategy
-- Uses current wall-clock time
+- Uses the current time of the engine's Clock
 - Returns false if no expiration strategy is configured
 */
 func (e *CacheEngine) IsExpired(ent *types.CacheEntry) bool {
 	return e.Expiration != nil &&
-		e.Expiration.IsExpired(ent, time.Now())
+		e.Expiration.IsExpired(ent, e.Now())
 }
 
 /*
@@ -108,10 +118,11 @@ This is where read-related behavior lives.
 Typical things that happen here:
 - Update TTL for expire-after-access strategies
 - Trigger a background refresh
-- Record refresh metrics
+
+Refresh metrics are reported by the hook itself, only when a reload actually happens.
 */
 func (e *CacheEngine) OnRead(key string, ent *types.CacheEntry) {
-	now := time.Now()
+	now := e.Now()
 
 	// Some expiration strategies (like sliding TTL) care about reads
 	if e.Expiration != nil {
@@ -121,7 +132,6 @@ func (e *CacheEngine) OnRead(key string, ent *types.CacheEntry) {
 	// Refresh is optional and best-effort.
 	// It should never slow down the read path.
 	if e.Refresh != nil {
-		e.Metrics.Refresh()
 		e.Refresh.OnRead(key, ent)
 	}
 }
@@ -134,25 +144,67 @@ This is where we:
 - Decide whether to push data to the backing store
 
 Write propagation depends entirely on the configured WritePolicy.
+Entries written with an explicit TTL (PutWithTTL) are temporary, cache-only
+values and are not forwarded. A TTL set by the expiration strategy does not count.
 */
 func (e *CacheEngine) OnWrite(ctx context.Context, ent *types.CacheEntry) {
-	now := time.Now()
+	explicitTTL := !ent.ExpireAt().IsZero()
 
-	// Some expiration strategies care about writes.
-	if e.Expiration != nil {
-		e.Expiration.OnWrite(ent, now)
-	}
+	e.OnLoad(ent)
 
-	if !ent.ExpireAt.IsZero() {
+	if explicitTTL {
 		return
 	}
+	e.Persist(ctx, ent)
+}
+
+/*
+Persist forwards a write to the write policy, if one is configured, without applying expiration rules.
 
-	// Forward the write if a write policy is configured.
+OnWrite calls it. The cache calls OnLoad and Persist separately instead, so that it can
+apply the rules under its shard lock but call the (possibly blocking) write policy outside of it.
+*/
+func (e *CacheEngine) Persist(ctx context.Context, ent *types.CacheEntry) {
 	if e.WritePolicy != nil {
 		e.WritePolicy.OnWrite(ctx, ent.Key, ent.Value)
 	}
 }
 
+/*
+OnDelete is called when a key is explicitly removed from the cache.
+The write policy decides whether, and when, the backing store deletes it too.
+*/
+func (e *CacheEngine) OnDelete(ctx context.Context, key string) {
+	if e.WritePolicy != nil {
+		e.WritePolicy.OnDelete(ctx, key)
+	}
+}
+
+/*
+Pending returns the latest write of key that the write policy has not delivered yet,
+if the policy holds writes back (writepolicy.PendingWrites).
+deleted is true for a removal. ok is false if nothing is pending for the key.
+*/
+func (e *CacheEngine) Pending(key string) (value any, deleted, ok bool) {
+	if p, isPending := e.WritePolicy.(writepolicy.PendingWrites); isPending {
+		return p.Pending(key)
+	}
+	return nil, false, false
+}
+
+/*
+OnLoad is called when a value loaded from the backing store is stored in the cache.
+
+It applies the same expiration rules as OnWrite, but does not go through the
+write policy: the backing store already has this value.
+*/
+func (e *CacheEngine) OnLoad(ent *types.CacheEntry) {
+	// Some expiration strategies care about writes.
+	if e.Expiration != nil {
+		e.Expiration.OnWrite(ent, e.Now())
+	}
+}
+
 /*
 Load is used when the cache does NOT have the data.
 
@@ -163,3 +215,39 @@ This usually means:
 func (e *CacheEngine) Load(ctx context.Context, key string) (any, error) {
 	return e.Loader.Load(ctx, key)
 }
+
+/*
+LoadAll loads several keys at once.
+
+If the Loader is a types.BatchLoader, this is one call to the backing store.
+Otherwise each key is loaded on its own: the keys that fail are reported
+in a types.KeyErrors, and the values of the others are kept.
+Keys that do not exist are left out of the result.
+*/
+func (e *CacheEngine) LoadAll(ctx context.Context, keys []string) (map[string]any, error) {
+	if b, ok := e.Loader.(types.BatchLoader); ok {
+		return b.LoadAll(ctx, keys)
+	}
+
+	vals := make(map[string]any, len(keys))
+	errs := make(types.KeyErrors)
+	for _, key := range keys {
+		// Nobody is waiting anymore
+		if err := ctx.Err(); err != nil {
+			return vals, err
+		}
+
+		val, err := e.Loader.Load(ctx, key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		if val != nil {
+			vals[key] = val
+		}
+	}
+	if len(errs) > 0 {
+		return vals, errs
+	}
+	return vals, nil
+}
@@ -0,0 +1,14 @@
+package cache
+
+import "errors"
+
+// ErrStale is returned by Get together with an expired value that was served because reloading it failed.
+// The error also wraps the load error; use errors.Is(err, ErrStale) to detect a stale value.
+var ErrStale = errors.New("cache: serving stale value")
+
+// ErrEntryTooLarge is returned by PutWithTTL when a single entry weighs more than a whole shard's budget.
+// Such an entry could never fit, no matter how many other entries are evicted.
+var ErrEntryTooLarge = errors.New("cache: entry exceeds shard capacity")
+
+// ErrNotFound is returned by Get when negative caching is enabled and the key does not exist in the backing store.
+var ErrNotFound = errors.New("cache: key not found")
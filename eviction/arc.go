@@ -0,0 +1,122 @@
+// This file implements ARC (Adaptive Replacement Cache) eviction.
+
+package eviction
+
+/*
+arc is the Adaptive Replacement Cache policy.
+
+It keeps four LRU lists:
+- t1: keys seen once recently          (recency)
+- t2: keys seen at least twice recently (frequency)
+- b1: ghost keys recently evicted from t1 (keys only, no data)
+- b2: ghost keys recently evicted from t2
+
+p is the target size of t1. ARC tunes it by itself:
+- A new key found in b1 means "t1 was too small" → grow p
+- A new key found in b2 means "t2 was too small" → shrink p
+
+A scan of one-off keys only passes through t1, so keys in t2 survive it.
+
+The cache does not tell policies its capacity, so c (the cache size ARC adapts within)
+is learned as the largest number of resident keys seen. Evict is only called when
+the shard is full, so this converges to the real capacity.
+*/
+type arc struct {
+	t1, t2 *lru
+	b1, b2 *lru
+
+	// p is the adaptive target size of t1.
+	p int
+
+	// c is the learned cache size.
+	c int
+}
+
+func newARC() *arc {
+	return &arc{
+		t1: newLRU(),
+		t2: newLRU(),
+		b1: newLRU(),
+		b2: newLRU(),
+	}
+}
+
+// OnGet is called whenever a key is read from the cache.
+// A hit in t1 means the key is now "frequent", so it moves to t2.
+func (a *arc) OnGet(k string) {
+	switch {
+	case a.t1.has(k):
+		a.t1.Remove(k)
+		a.t2.OnPut(k)
+	case a.t2.has(k):
+		a.t2.OnGet(k)
+	}
+}
+
+// OnPut is called when a key is added to the cache.
+// This is where ARC adapts p using the ghost lists.
+func (a *arc) OnPut(k string) {
+	if a.t1.has(k) || a.t2.has(k) {
+		// Key already tracked
+		return
+	}
+
+	switch {
+	case a.b1.has(k):
+		// Evicted from t1 too early: favor recency
+		a.p = min(a.c, a.p+max(a.b2.len()/a.b1.len(), 1))
+		a.b1.Remove(k)
+		a.t2.OnPut(k)
+
+	case a.b2.has(k):
+		// Evicted from t2 too early: favor frequency
+		a.p = max(0, a.p-max(a.b1.len()/a.b2.len(), 1))
+		a.b2.Remove(k)
+		a.t2.OnPut(k)
+
+	default:
+		a.t1.OnPut(k)
+	}
+
+	a.c = max(a.c, a.t1.len()+a.t2.len())
+	a.trimGhosts()
+}
+
+// Evict is called when the cache is full.
+// It evicts from t1 while t1 is above its target p, otherwise from t2.
+// The evicted key is remembered in the matching ghost list.
+func (a *arc) Evict() string {
+	if a.t1.len() > 0 && (a.t1.len() > a.p || a.t2.len() == 0) {
+		k := a.t1.Evict()
+		a.b1.OnPut(k)
+		a.trimGhosts()
+		return k
+	}
+
+	k := a.t2.Evict()
+	if k != "" {
+		a.b2.OnPut(k)
+		a.trimGhosts()
+	}
+	return k
+}
+
+// Remove is called when a key is explicitly removed (not evicted due to capacity).
+// An explicit removal says nothing about recency or frequency, so no ghost is kept.
+func (a *arc) Remove(k string) {
+	a.t1.Remove(k)
+	a.t2.Remove(k)
+	a.b1.Remove(k)
+	a.b2.Remove(k)
+}
+
+// trimGhosts keeps the directory bounded:
+// t1+b1 never exceeds c, and all four lists together never exceed 2c.
+func (a *arc) trimGhosts() {
+	for a.b1.len() > 0 && a.t1.len()+a.b1.len() > a.c {
+		a.b1.Evict()
+	}
+	for a.b2.len() > 0 && a.t1.len()+a.t2.len()+a.b1.len()+a.b2.len() > 2*a.c {
+		a.b2.Evict()
+	}
+}
@@ -49,6 +49,20 @@ type Policy interface {
 	Evict() string
 }
 
+/*
+Admitter is an optional extension of Policy.
+
+Some strategies (like TinyLFU) do not just pick a victim, they also decide whether a NEW key
+is worth caching at all. When a shard is full and a new key arrives, the cache asks the policy
+first. If the key is rejected, nothing is evicted and the key is simply not stored in memory.
+*/
+type Admitter interface {
+
+	// Admit is called before a NEW key is stored in a full shard.
+	// It returns false if the key should not be cached.
+	Admit(string) bool
+}
+
 // PolicyType is a simple identifier for supported eviction strategies.
 type PolicyType string
 
@@ -64,6 +78,20 @@ const (
 
 	// FIFO (First In First Out): Evicts the oldest inserted key, regardless of access.
 	FIFO PolicyType = "FIFO"
+
+	// S3FIFO: A small probationary FIFO, a main FIFO and a ghost FIFO with 2-bit access counters.
+	// Matches LRU hit ratios while reads only bump a counter instead of reordering a list.
+	S3FIFO PolicyType = "S3FIFO"
+
+	// TinyLFU (W-TinyLFU): A small LRU window in front of a segmented LRU main area.
+	// Keys only move from the window into the main area if a frequency sketch says they are
+	// used more often than the key they would replace. One-off scans cannot flush hot keys.
+	TinyLFU PolicyType = "TinyLFU"
+
+	// ARC (Adaptive Replacement Cache): Balances a recency list and a frequency list,
+	// and uses "ghost" lists of recently evicted keys to tune the split between them by itself.
+	// This works well when traffic moves between scans and stable hot sets.
+	ARC PolicyType = "ARC"
 )
 
 // NewEvictionPolicy is a small factory function.
@@ -76,6 +104,12 @@ func NewEvictionPolicy(t PolicyType) Policy {
 		return newLFU()
 	case FIFO:
 		return newFIFO()
+	case S3FIFO:
+		return newS3FIFO()
+	case TinyLFU:
+		return newTinyLFU()
+	case ARC:
+		return newARC()
 	default:
 		panic("unknown eviction policy")
 	}
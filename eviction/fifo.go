@@ -1,4 +1,4 @@
-// This file implements FIFO eviction.
+// This file implements FIFO and S3-FIFO eviction.
 
 package eviction
 
@@ -75,3 +75,139 @@ func (f *fifo) Remove(k string) {
 		}
 	}
 }
+
+const (
+	// s3fifoSmallPercent is the share of keys kept in the small probationary queue.
+	s3fifoSmallPercent = 10
+
+	// s3fifoMaxFreq is the ceiling of the 2-bit access counters.
+	s3fifoMaxFreq = 3
+)
+
+/*
+s3fifo implements S3-FIFO eviction.
+
+It uses three FIFO queues:
+- small: probationary queue for new keys (about 10% of keys)
+- main:  keys that proved useful while in small
+- ghost: keys recently evicted from small (keys only, no data)
+
+Each key has a 2-bit access counter. Reads only bump the counter; nothing is
+moved on OnGet, which keeps the hot read path cheap.
+
+On eviction:
+- small's oldest key moves to main if it was read, otherwise it is evicted (and remembered in ghost)
+- main's oldest key is reinserted (counter decremented) if it was read, otherwise evicted
+- a new key found in ghost goes straight into main
+
+Every queue is a doubly-linked list with a key index, so Remove is O(1).
+*/
+type s3fifo struct {
+	// small, main and ghost are used as plain FIFO queues: new keys at the front, oldest at the back.
+	small *lru
+	main  *lru
+	ghost *lru
+
+	// freq holds the 2-bit access counter of every resident key.
+	freq map[string]uint8
+}
+
+func newS3FIFO() *s3fifo {
+	return &s3fifo{
+		small: newLRU(),
+		main:  newLRU(),
+		ghost: newLRU(),
+		freq:  make(map[string]uint8),
+	}
+}
+
+// OnGet is called when a key is read from the cache. It only bumps the counter.
+func (s *s3fifo) OnGet(k string) {
+	if f, ok := s.freq[k]; ok && f < s3fifoMaxFreq {
+		s.freq[k] = f + 1
+	}
+}
+
+// OnPut is called when a key is added to the cache.
+// Keys remembered in ghost were evicted too early, so they skip probation.
+func (s *s3fifo) OnPut(k string) {
+	if _, ok := s.freq[k]; ok {
+		// Key already tracked
+		return
+	}
+
+	s.freq[k] = 0
+	if s.ghost.has(k) {
+		s.ghost.Remove(k)
+		s.main.OnPut(k)
+		return
+	}
+	s.small.OnPut(k)
+}
+
+// Evict is called when the cache is full. It returns the key to be evicted.
+func (s *s3fifo) Evict() string {
+	for {
+		total := s.small.len() + s.main.len()
+		if total == 0 {
+			return ""
+		}
+
+		if s.small.len() > 0 && (s.small.len() > total*s3fifoSmallPercent/100 || s.main.len() == 0) {
+			if k := s.evictSmall(); k != "" {
+				return k
+			}
+			continue
+		}
+
+		return s.evictMain()
+	}
+}
+
+// evictSmall takes the oldest key out of small.
+// It returns "" if that key was promoted to main instead of being evicted.
+func (s *s3fifo) evictSmall() string {
+	k := s.small.Evict()
+
+	if s.freq[k] > 0 {
+		s.freq[k] = 0
+		s.main.OnPut(k)
+		return ""
+	}
+
+	delete(s.freq, k)
+	s.ghost.OnPut(k)
+
+	// ghost remembers about as many keys as main holds
+	for s.ghost.len() > max(s.main.len(), 1) {
+		s.ghost.Evict()
+	}
+	return k
+}
+
+// evictMain takes the oldest unread key out of main, giving read keys another lap.
+func (s *s3fifo) evictMain() string {
+	for {
+		k := s.main.Evict()
+		if f := s.freq[k]; f > 0 {
+			s.freq[k] = f - 1
+			s.main.OnPut(k)
+			continue
+		}
+
+		delete(s.freq, k)
+		return k
+	}
+}
+
+// Remove is called when a key is explicitly removed from the cache. Every queue removal is O(1).
+func (s *s3fifo) Remove(k string) {
+	if _, ok := s.freq[k]; !ok {
+		// Key not tracked; do nothing
+		return
+	}
+
+	delete(s.freq, k)
+	s.small.Remove(k)
+	s.main.Remove(k)
+}
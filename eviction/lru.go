@@ -81,6 +81,8 @@ func (l *lru) Remove(k string) {
 
 // addFront adds a node to the front of the linked list. This marks the node as "most recently used".
 func (l *lru) addFront(n *lruNode) {
+	// Clear any stale link left over from a previous position
+	n.prev = nil
 	n.next = l.head
 	if l.head != nil {
 		l.head.prev = n
@@ -119,3 +121,22 @@ func (l *lru) moveToFront(n *lruNode) {
 	l.remove(n)
 	l.addFront(n)
 }
+
+// len returns how many keys are tracked.
+func (l *lru) len() int {
+	return len(l.nodes)
+}
+
+// back returns the least recently used key without removing it, or "" if empty.
+func (l *lru) back() string {
+	if l.tail == nil {
+		return ""
+	}
+	return l.tail.key
+}
+
+// has reports whether the key is tracked.
+func (l *lru) has(k string) bool {
+	_, ok := l.nodes[k]
+	return ok
+}
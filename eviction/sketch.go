@@ -0,0 +1,135 @@
+// This file implements the count-min sketch used by TinyLFU.
+
+package eviction
+
+import "hash/fnv"
+
+const (
+	// sketchDepth is the number of hash rows. More rows means fewer over-estimates.
+	sketchDepth = 4
+
+	// sketchMaxCount is the largest value a counter can hold (4-bit counters).
+	sketchMaxCount = 15
+
+	// sketchCountersPerKey is how many counters each row has per expected key.
+	// Spare counters keep collisions (and so over-estimates) rare.
+	sketchCountersPerKey = 8
+
+	// sketchSampleFactor decides how often counters age: after this many increments per expected key.
+	sketchSampleFactor = 10
+
+	// sketchMinKeys is the smallest number of keys a sketch is sized for.
+	sketchMinKeys = 64
+)
+
+/*
+countMinSketch estimates how often each key was seen, using a fixed amount of memory.
+
+Each key maps to one counter per row. Increment bumps all of them; Estimate
+returns the smallest one. Collisions can only make the estimate too high, never too low.
+
+Aging:
+------
+After sampleSize increments (10 per expected key), every counter is halved. Old popularity fades away so keys
+that WERE hot do not stay protected forever.
+*/
+type countMinSketch struct {
+	// rows holds sketchDepth rows of counters.
+	rows [sketchDepth][]uint8
+
+	// mask is width-1; width is always a power of two.
+	mask uint64
+
+	// keys is the number of distinct keys the sketch was sized for.
+	keys int
+
+	// additions counts increments since the last reset.
+	additions int
+
+	// sampleSize is how many increments trigger an aging reset.
+	sampleSize int
+}
+
+// newCountMinSketch creates a sketch sized for roughly the given number of distinct keys.
+func newCountMinSketch(keys int) *countMinSketch {
+	keys = max(keys, sketchMinKeys)
+
+	w := 1
+	for w < keys*sketchCountersPerKey {
+		w <<= 1
+	}
+
+	s := &countMinSketch{
+		mask:       uint64(w - 1),
+		keys:       keys,
+		sampleSize: sketchSampleFactor * keys,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// capacity returns the number of distinct keys the sketch was sized for.
+func (s *countMinSketch) capacity() int {
+	return s.keys
+}
+
+// Increment records one occurrence of the key.
+func (s *countMinSketch) Increment(k string) {
+	h1, h2 := sketchHash(k)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < sketchMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// Estimate returns the approximate number of times the key was seen.
+func (s *countMinSketch) Estimate(k string) uint8 {
+	h1, h2 := sketchHash(k)
+	min := uint8(sketchMaxCount)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if c := s.rows[i][idx]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// raise lifts every counter of the key to at least n.
+// It is used to carry frequencies over into a larger sketch.
+func (s *countMinSketch) raise(k string, n uint8) {
+	h1, h2 := sketchHash(k)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < n {
+			s.rows[i][idx] = n
+		}
+	}
+}
+
+// reset halves every counter. This is the periodic aging step.
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// sketchHash derives two independent hashes from one FNV hash (double hashing).
+func sketchHash(k string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(k))
+	sum := h.Sum64()
+	return sum & 0xffffffff, (sum >> 32) | 1
+}
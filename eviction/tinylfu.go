@@ -0,0 +1,187 @@
+// This file implements W-TinyLFU eviction.
+
+package eviction
+
+const (
+	// tinyLFUWindowPercent is the share of keys kept in the admission window.
+	tinyLFUWindowPercent = 1
+
+	// tinyLFUProtectedPercent is the share of the main area reserved for keys accessed more than once.
+	tinyLFUProtectedPercent = 80
+)
+
+/*
+tinyLFU is the W-TinyLFU eviction policy.
+
+Keys live in one of three LRU lists:
+- window:    every new key starts here (about 1% of all keys)
+- probation: main-area keys that were not accessed again yet
+- protected: main-area keys that were accessed at least once more (about 80% of main)
+
+When the cache is full, the oldest window key (the candidate) competes with the oldest
+probation key (the victim). The frequency sketch decides who stays:
+- candidate is more popular → it moves into probation and the victim is evicted
+- otherwise → the candidate is evicted
+
+A scan of one-off keys only ever churns the window; it cannot flush the hot keys in main.
+
+If the shard is too small for a window (under 100 keys), new keys are instead filtered at
+the door by Admit, using the same frequency comparison.
+*/
+type tinyLFU struct {
+	// sketch estimates access frequency, including for keys no longer cached.
+	sketch *countMinSketch
+
+	// window, probation and protected are the three LRU segments.
+	window    *lru
+	probation *lru
+	protected *lru
+}
+
+func newTinyLFU() *tinyLFU {
+	return &tinyLFU{
+		sketch:    newCountMinSketch(sketchMinKeys),
+		window:    newLRU(),
+		probation: newLRU(),
+		protected: newLRU(),
+	}
+}
+
+// OnGet is called whenever a key is read from the cache.
+// The access is recorded in the sketch and the key is promoted within its segment.
+func (t *tinyLFU) OnGet(k string) {
+	t.sketch.Increment(k)
+
+	switch {
+	case t.window.has(k):
+		t.window.OnGet(k)
+
+	case t.probation.has(k):
+		// Second access in main: promote to protected
+		t.probation.Remove(k)
+		t.protected.OnPut(k)
+
+		// Keep protected within its share by demoting its LRU key
+		if t.protected.len() > t.protectedShare() {
+			demoted := t.protected.Evict()
+			t.probation.OnPut(demoted)
+		}
+
+	case t.protected.has(k):
+		t.protected.OnGet(k)
+	}
+}
+
+// OnPut is called when a key is added to the cache. New keys always enter the window.
+func (t *tinyLFU) OnPut(k string) {
+	t.sketch.Increment(k)
+
+	if t.window.has(k) || t.probation.has(k) || t.protected.has(k) {
+		// Key already tracked
+		return
+	}
+
+	t.window.OnPut(k)
+
+	// While the cache is filling up, the window overflows into probation without any eviction
+	for t.window.len() > t.windowShare() {
+		t.probation.OnPut(t.window.Evict())
+	}
+
+	// Grow the sketch with the number of tracked keys so estimates stay accurate
+	if t.size() > t.sketch.capacity() {
+		t.growSketch()
+	}
+}
+
+// growSketch doubles the sketch, carrying over the frequencies of tracked keys.
+// Doubling keeps the copying cost amortized O(1) per insert.
+func (t *tinyLFU) growSketch() {
+	old := t.sketch
+	t.sketch = newCountMinSketch(2 * t.size())
+	for _, seg := range []*lru{t.window, t.probation, t.protected} {
+		for k := range seg.nodes {
+			t.sketch.raise(k, old.Estimate(k))
+		}
+	}
+}
+
+// Admit is called before a new key is stored in a full shard.
+// Only shards too small for a window filter at the door; otherwise the window absorbs new keys.
+func (t *tinyLFU) Admit(k string) bool {
+	if t.windowShare() > 0 {
+		return true
+	}
+
+	victim := t.mainVictim()
+	if victim == "" {
+		return true
+	}
+
+	// Count this attempt, so a key that keeps coming back is eventually admitted
+	if t.sketch.Estimate(k)+1 > t.sketch.Estimate(victim) {
+		return true
+	}
+	t.sketch.Increment(k)
+	return false
+}
+
+// Evict is called when the cache is full.
+func (t *tinyLFU) Evict() string {
+
+	// A new key is about to enter the window, so the window's LRU key has to
+	// either earn a place in main or leave the cache.
+	if t.window.len() > 0 && t.window.len() >= t.windowShare() {
+		candidate := t.window.back()
+		victim := t.mainVictim()
+
+		if victim != "" && t.sketch.Estimate(candidate) > t.sketch.Estimate(victim) {
+			t.window.Remove(candidate)
+			t.probation.OnPut(candidate)
+			t.Remove(victim)
+			return victim
+		}
+
+		t.window.Remove(candidate)
+		return candidate
+	}
+
+	victim := t.mainVictim()
+	if victim == "" {
+		// Main is empty; fall back to the window
+		return t.window.Evict()
+	}
+	t.Remove(victim)
+	return victim
+}
+
+// Remove is called when a key is explicitly removed (not evicted due to capacity).
+func (t *tinyLFU) Remove(k string) {
+	t.window.Remove(k)
+	t.probation.Remove(k)
+	t.protected.Remove(k)
+}
+
+// mainVictim returns the key main would give up first: the LRU probation key,
+// or the LRU protected key if probation is empty.
+func (t *tinyLFU) mainVictim() string {
+	if v := t.probation.back(); v != "" {
+		return v
+	}
+	return t.protected.back()
+}
+
+// size returns the number of keys tracked across all segments.
+func (t *tinyLFU) size() int {
+	return t.window.len() + t.probation.len() + t.protected.len()
+}
+
+// windowShare returns the target size of the window.
+func (t *tinyLFU) windowShare() int {
+	return t.size() * tinyLFUWindowPercent / 100
+}
+
+// protectedShare returns the maximum size of the protected segment.
+func (t *tinyLFU) protectedShare() int {
+	return (t.probation.len() + t.protected.len()) * tinyLFUProtectedPercent / 100
+}
@@ -19,7 +19,7 @@ type ExpireAfterAccess struct {
 
 // IsExpired checks whether the entry is expired at this moment.
 func (e *ExpireAfterAccess) IsExpired(ent *types.CacheEntry, now time.Time) bool {
-	return !ent.ExpireAt.IsZero() && now.After(ent.ExpireAt)
+	return !ent.ExpireAt().IsZero() && now.After(ent.ExpireAt())
 }
 
 /*
@@ -28,8 +28,8 @@ OnAccess is called every time the cache successfully returns a value. This is th
 2. Push ExpireAt forward by TTL
 */
 func (e *ExpireAfterAccess) OnAccess(ent *types.CacheEntry, now time.Time) {
-	ent.LastAccessedAt = now
-	ent.ExpireAt = now.Add(e.TTL)
+	ent.SetLastAccessedAt(now)
+	ent.SetExpireAt(now.Add(e.TTL))
 }
 
 /*
@@ -43,10 +43,10 @@ We only set ExpireAt if it is currently zero. Because the caller might have expl
 */
 func (e *ExpireAfterAccess) OnWrite(ent *types.CacheEntry, now time.Time) {
 	ent.CreatedAt = now
-	ent.LastAccessedAt = now
+	ent.SetLastAccessedAt(now)
 
 	// Only set expiration if it wasn't explicitly set before
-	if ent.ExpireAt.IsZero() {
-		ent.ExpireAt = now.Add(e.TTL)
+	if ent.ExpireAt().IsZero() {
+		ent.SetExpireAt(now.Add(e.TTL))
 	}
 }
@@ -0,0 +1,39 @@
+package expiration
+
+import (
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+ExpireAfterWrite implements a fixed TTL counted from when the entry was written.
+Reads do NOT push the expiration forward. The data goes stale after TTL,
+no matter how often it is read. Use it for data that must be reloaded regularly.
+*/
+type ExpireAfterWrite struct {
+
+	// TTL (Time-To-Live) defines how long the entry stays valid AFTER it is written.
+	TTL time.Duration
+}
+
+// IsExpired checks whether the entry is expired at this moment.
+func (e *ExpireAfterWrite) IsExpired(ent *types.CacheEntry, now time.Time) bool {
+	return !ent.ExpireAt().IsZero() && now.After(ent.ExpireAt())
+}
+
+// OnAccess only records the access time. The expiration time is left untouched.
+func (e *ExpireAfterWrite) OnAccess(ent *types.CacheEntry, now time.Time) {
+	ent.SetLastAccessedAt(now)
+}
+
+// OnWrite starts the TTL from the write, unless the entry already has an explicit TTL.
+func (e *ExpireAfterWrite) OnWrite(ent *types.CacheEntry, now time.Time) {
+	ent.CreatedAt = now
+	ent.SetLastAccessedAt(now)
+
+	// Only set expiration if it wasn't explicitly set before
+	if ent.ExpireAt().IsZero() {
+		ent.SetExpireAt(now.Add(e.TTL))
+	}
+}
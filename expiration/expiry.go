@@ -0,0 +1,33 @@
+package expiration
+
+import "time"
+
+/*
+Expiry computes a TTL for each entry from its key and value.
+
+A Strategy applies the same rule to every entry. Expiry is for data that carries
+its own lifetime, for example:
+- Auth tokens with an "exp" claim
+- HTTP responses with Cache-Control: max-age
+
+Return values:
+--------------
+- > 0  : the entry expires that long from now
+- <= 0 : the entry does not expire
+
+The "current" argument is the entry's remaining TTL (0 if it has none).
+Return it unchanged to keep the existing expiration.
+
+Explicit TTLs from PutWithTTL always win; Expiry is only asked when no TTL was given.
+*/
+type Expiry interface {
+
+	// ExpireAfterCreate is called when a new key is written (including read-through loads).
+	ExpireAfterCreate(key string, value any, now time.Time) time.Duration
+
+	// ExpireAfterUpdate is called when an existing key is overwritten.
+	ExpireAfterUpdate(key string, value any, now time.Time, current time.Duration) time.Duration
+
+	// ExpireAfterRead is called on every cache hit. It is on the hot read path, so keep it cheap.
+	ExpireAfterRead(key string, value any, now time.Time, current time.Duration) time.Duration
+}
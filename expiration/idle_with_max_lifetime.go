@@ -0,0 +1,56 @@
+package expiration
+
+import (
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+IdleWithMaxLifetime combines an idle timeout with an absolute max lifetime, like a session store.
+
+- Every read pushes the expiration forward by Idle (sliding TTL)
+- But never past CreatedAt + MaxLifetime
+
+So an entry expires when it is left unused for Idle, OR when it gets older than MaxLifetime,
+whichever comes first.
+*/
+type IdleWithMaxLifetime struct {
+
+	// Idle is how long the entry stays valid after its last access.
+	Idle time.Duration
+
+	// MaxLifetime is how long the entry may live after it was written, however often it is read.
+	MaxLifetime time.Duration
+}
+
+// IsExpired checks whether the entry is expired at this moment.
+func (e *IdleWithMaxLifetime) IsExpired(ent *types.CacheEntry, now time.Time) bool {
+	return !ent.ExpireAt().IsZero() && now.After(ent.ExpireAt())
+}
+
+// OnAccess slides the expiration forward by Idle, capped at the max lifetime.
+func (e *IdleWithMaxLifetime) OnAccess(ent *types.CacheEntry, now time.Time) {
+	ent.SetLastAccessedAt(now)
+	ent.SetExpireAt(e.deadline(ent, now))
+}
+
+// OnWrite starts both clocks: the lifetime and the first idle period. An explicit TTL is kept.
+func (e *IdleWithMaxLifetime) OnWrite(ent *types.CacheEntry, now time.Time) {
+	ent.CreatedAt = now
+	ent.SetLastAccessedAt(now)
+
+	// Only set expiration if it wasn't explicitly set before
+	if ent.ExpireAt().IsZero() {
+		ent.SetExpireAt(e.deadline(ent, now))
+	}
+}
+
+// deadline returns the earlier of the idle deadline and the end of the entry's lifetime.
+func (e *IdleWithMaxLifetime) deadline(ent *types.CacheEntry, now time.Time) time.Time {
+	idle := now.Add(e.Idle)
+	if end := ent.CreatedAt.Add(e.MaxLifetime); end.Before(idle) {
+		return end
+	}
+	return idle
+}
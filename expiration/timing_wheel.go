@@ -0,0 +1,237 @@
+// This file implements a hierarchical timing wheel for scheduling expirations.
+
+package expiration
+
+import (
+	"sync"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+const (
+	// wheelBits is log2 of the number of slots per level.
+	wheelBits = 6
+
+	// wheelSize is the number of slots per level (64).
+	wheelSize = 1 << wheelBits
+
+	// wheelMask selects a slot index.
+	wheelMask = wheelSize - 1
+
+	// wheelLevels is the number of levels. With 64 slots each, four levels cover
+	// 64^4 (~16.7M) ticks; timers further out are parked and re-placed later.
+	wheelLevels = 4
+)
+
+// timer is one scheduled expiration.
+type timer struct {
+	key     string
+	expTick uint64 // tick at which the timer fires
+	level   int    // level of the slot holding this timer
+	slot    int    // slot index holding this timer
+}
+
+/*
+TimingWheel fires a callback for each key close to its deadline,
+without relying on reads or random sampling.
+
+How it works:
+-------------
+Time is cut into ticks. Level 0 has 64 slots, one per tick. Level 1 has 64 slots
+of 64 ticks each, level 2 slots span 64^2 ticks, and so on (like the hands of a clock).
+
+  - Schedule puts a timer into the lowest level whose range covers its deadline: O(1)
+  - Cancel removes it from its slot: O(1)
+  - Every tick, level 0's current slot fires. Whenever a lower level wraps around,
+    the next slot of the level above is "cascaded" down into finer slots.
+
+A single goroutine drives the wheel, so millions of timers cost no goroutines.
+Timers fire up to one tick late, never early.
+*/
+type TimingWheel struct {
+	// mu protects everything below.
+	mu sync.Mutex
+
+	// tick is the resolution of the wheel.
+	tick time.Duration
+
+	// clock tells the wheel what time it is.
+	clock types.Clock
+
+	// start is the time of tick 0.
+	start time.Time
+
+	// current is the last tick that was processed.
+	current uint64
+
+	// slots holds the timers of each level, keyed by cache key.
+	slots [wheelLevels][wheelSize]map[string]*timer
+
+	// timers indexes every scheduled timer by key, for O(1) cancel and reschedule.
+	timers map[string]*timer
+
+	// onExpire is called (outside the lock) for every key whose timer fired.
+	onExpire func(key string)
+
+	// stop tells the driver goroutine to exit; wg waits for it.
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+/*
+NewTimingWheel creates a timing wheel with the given tick and starts its driver goroutine.
+The driver wakes up every tick of real time, then catches up to the time of the given clock.
+*/
+func NewTimingWheel(tick time.Duration, clock types.Clock, onExpire func(key string)) *TimingWheel {
+	w := &TimingWheel{
+		tick:     tick,
+		clock:    clock,
+		start:    clock.Now(),
+		timers:   make(map[string]*timer),
+		onExpire: onExpire,
+		stop:     make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w
+}
+
+// Schedule sets (or moves) the deadline of a key. Deadlines in the past fire on the next tick.
+func (w *TimingWheel) Schedule(key string, deadline time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.cancel(key)
+
+	// Round up so a timer never fires before its deadline
+	d := deadline.Sub(w.start)
+	expTick := uint64(0)
+	if d > 0 {
+		expTick = uint64((d + w.tick - 1) / w.tick)
+	}
+	if expTick <= w.current {
+		expTick = w.current + 1
+	}
+
+	t := &timer{key: key, expTick: expTick}
+	w.timers[key] = t
+	w.place(t)
+}
+
+// Cancel removes the deadline of a key, if any.
+func (w *TimingWheel) Cancel(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cancel(key)
+}
+
+// Len returns how many timers are scheduled.
+func (w *TimingWheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.timers)
+}
+
+// Close stops the driver goroutine. Pending timers are dropped.
+func (w *TimingWheel) Close() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+// cancel removes a timer. Caller holds mu.
+func (w *TimingWheel) cancel(key string) {
+	t, ok := w.timers[key]
+	if !ok {
+		return
+	}
+	delete(w.slots[t.level][t.slot], key)
+	delete(w.timers, key)
+}
+
+// place puts a timer into the lowest level whose range covers its deadline. Caller holds mu.
+func (w *TimingWheel) place(t *timer) {
+	delta := uint64(0)
+	if t.expTick > w.current {
+		delta = t.expTick - w.current
+	}
+
+	t.level = wheelLevels - 1
+	t.slot = int((w.current>>(wheelBits*t.level))-1) & wheelMask // farthest slot: re-placed on cascade
+
+	for level := 0; level < wheelLevels; level++ {
+		if delta < 1<<(wheelBits*(level+1)) {
+			t.level = level
+			t.slot = int(t.expTick>>(wheelBits*level)) & wheelMask
+			break
+		}
+	}
+
+	if w.slots[t.level][t.slot] == nil {
+		w.slots[t.level][t.slot] = make(map[string]*timer)
+	}
+	w.slots[t.level][t.slot][t.key] = t
+}
+
+// advance processes one tick and returns the keys whose timers fired. Caller holds mu.
+func (w *TimingWheel) advance(fired []string) []string {
+	w.current++
+
+	// Cascade from the top down, so timers can fall through several levels in one tick
+	for level := wheelLevels - 1; level >= 1; level-- {
+		if w.current&(1<<(wheelBits*level)-1) != 0 {
+			continue
+		}
+		idx := int(w.current>>(wheelBits*level)) & wheelMask
+		timers := w.slots[level][idx]
+		w.slots[level][idx] = nil
+		for _, t := range timers {
+			w.place(t)
+		}
+	}
+
+	idx := int(w.current) & wheelMask
+	for key := range w.slots[0][idx] {
+		delete(w.timers, key)
+		fired = append(fired, key)
+	}
+	w.slots[0][idx] = nil
+
+	return fired
+}
+
+// run drives the wheel: on every tick it catches up to the current time and fires due timers.
+func (w *TimingWheel) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			elapsed := w.clock.Now().Sub(w.start)
+			if elapsed < 0 {
+				// Clock moved back before the wheel started; nothing can be due
+				continue
+			}
+			target := uint64(elapsed / w.tick)
+
+			var fired []string
+			w.mu.Lock()
+			for w.current < target {
+				fired = w.advance(fired)
+			}
+			w.mu.Unlock()
+
+			// Callbacks run outside the lock, so they may reschedule
+			for _, key := range fired {
+				w.onExpire(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+loadGroup deduplicates loads from the backing store, like singleflight.
+
+If 100 goroutines request the same missing key, only ONE of them loads it.
+The others wait for that load and share its result. Unlike singleflight,
+several keys can be claimed at once (DoBatch), so a batch load and single-key
+loads of overlapping keys still never load the same key twice.
+
+Cancellation is per caller:
+  - A load runs on a context detached from its callers, so one caller
+    giving up does not fail the load for everyone else
+  - Each caller stops waiting as soon as its own ctx is done
+  - A load is cancelled only once every caller waiting for it has gone
+*/
+type loadGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is one in-progress load of a key.
+type flight struct {
+	done chan struct{}
+	res  loaded
+	err  error
+
+	// waiters is the number of callers still waiting. Guarded by loadGroup.mu.
+	waiters int
+
+	// run is the backing-store call this key is loaded by.
+	run *loadRun
+}
+
+// loadRun is one call to the backing store, loading one or more keys.
+type loadRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wanted is the number of its keys that still have waiters. Guarded by loadGroup.mu.
+	wanted int
+}
+
+/*
+Do loads a single key with fn.
+If the key is already being loaded, Do waits for that load instead.
+*/
+func (g *loadGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) (loaded, error)) (loaded, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		run := g.newRun(ctx, 1)
+		f = g.start(key, run)
+		go g.load(run, map[string]*flight{key: f}, func(ctx context.Context, _ []string) (map[string]loaded, error) {
+			res, err := fn(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]loaded{key: res}, nil
+		})
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.res, f.err
+	case <-ctx.Done():
+		g.leave(key, f)
+		return loaded{}, ctx.Err()
+	}
+}
+
+/*
+DoBatch loads several keys.
+
+Keys that nobody is loading yet are claimed and loaded together by one call to fn.
+Keys already being loaded are waited for. It returns the finished flight of every key,
+or ctx's error if ctx is done first.
+*/
+func (g *loadGroup) DoBatch(
+	ctx context.Context,
+	keys []string,
+	fn func(ctx context.Context, keys []string) (map[string]loaded, error),
+) (map[string]*flight, error) {
+	flights := make(map[string]*flight, len(keys))
+	claimed := make(map[string]*flight)
+
+	g.mu.Lock()
+	run := g.newRun(ctx, 0)
+	for _, key := range keys {
+		if _, dup := flights[key]; dup {
+			continue
+		}
+		f, ok := g.flights[key]
+		if !ok {
+			f = g.start(key, run)
+			claimed[key] = f
+		}
+		f.waiters++
+		flights[key] = f
+	}
+	run.wanted = len(claimed)
+	if len(claimed) > 0 {
+		go g.load(run, claimed, fn)
+	} else {
+		run.cancel()
+	}
+	g.mu.Unlock()
+
+	for _, f := range flights {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			for key, f := range flights {
+				g.leave(key, f)
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return flights, nil
+}
+
+// newRun creates a backing-store call whose context keeps ctx's values but not its cancellation.
+func (g *loadGroup) newRun(ctx context.Context, wanted int) *loadRun {
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	return &loadRun{ctx: runCtx, cancel: cancel, wanted: wanted}
+}
+
+// start registers a new flight for key. It must be called with g.mu held.
+func (g *loadGroup) start(key string, run *loadRun) *flight {
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{}), run: run}
+	g.flights[key] = f
+	return f
+}
+
+/*
+load calls the backing store for the claimed keys and finishes their flights.
+
+fn may return partial results along with an error: keys it returned a result for
+still succeed, only the others get the error. If the error is a types.KeyErrors,
+each key gets its own error, and keys without one are simply not found.
+*/
+func (g *loadGroup) load(run *loadRun, claimed map[string]*flight, fn func(context.Context, []string) (map[string]loaded, error)) {
+	defer run.cancel()
+
+	keys := make([]string, 0, len(claimed))
+	for key := range claimed {
+		keys = append(keys, key)
+	}
+
+	results, err := fn(run.ctx, keys)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, f := range claimed {
+		res, ok := results[key]
+		f.res = res
+		if !ok {
+			f.err = keyErr(err, key)
+		}
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		close(f.done)
+	}
+}
+
+/*
+leave is called when a waiter's ctx is done before its flight finished.
+
+When the last waiter of a key leaves, the key is dropped, so later callers start a fresh load.
+When no key of a backing-store call is wanted anymore, the call is cancelled.
+*/
+func (g *loadGroup) leave(key string, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f.waiters--
+	if f.waiters > 0 || g.flights[key] != f {
+		return
+	}
+
+	delete(g.flights, key)
+	f.run.wanted--
+	if f.run.wanted == 0 {
+		f.run.cancel()
+	}
+}
@@ -3,5 +3,3 @@ module github.com/krisalay/in-memory-cache
 go 1.24.0
 
 toolchain go1.24.12
-
-require golang.org/x/sync v0.19.0 // indirect
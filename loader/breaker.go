@@ -0,0 +1,158 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+// ErrBreakerOpen is returned without calling the backing store while the circuit breaker is open.
+var ErrBreakerOpen = errors.New("loader: circuit breaker is open")
+
+/*
+Breaker is a circuit breaker that stops calling a backing store that keeps failing.
+
+STATES:
+-------
+- Closed: calls go through. After `failures` consecutive failures, the breaker opens.
+- Open: calls fail fast with ErrBreakerOpen. After `cooldown`, the breaker goes half-open.
+- Half-open: one trial call goes through. Success closes the breaker, failure opens it again.
+
+While the breaker is open, the cache fails fast. To serve stale values instead,
+enable stale-if-error on the cache (WithStaleIfError): an expired entry within its
+grace window is returned when the load fails with ErrBreakerOpen.
+
+Calls cancelled by their caller (context.Canceled) do not count as failures. Calls let through
+while closed that finish after the breaker opened do not count either: only the trial call decides.
+Every state change is reported through Metrics.BreakerStateChanged, if Metrics implements types.BreakerMetrics.
+*/
+type Breaker struct {
+	// Name identifies this breaker in metrics.
+	Name string
+
+	// Metrics receives state changes. Defaults to NoopMetrics.
+	Metrics types.Metrics
+
+	// Clock measures the cooldown. Defaults to the system clock; tests can swap in a fake clock.
+	Clock types.Clock
+
+	failures int
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	state    types.BreakerState
+	failed   int       // consecutive failures while closed
+	openedAt time.Time // when the breaker last opened
+	probing  bool      // a half-open trial call is running
+}
+
+/*
+NewCircuitBreaker creates a closed breaker that opens after `failures` consecutive failures
+and lets a trial call through once `cooldown` has passed.
+*/
+func NewCircuitBreaker(name string, failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Name:     name,
+		Metrics:  types.NoopMetrics{},
+		Clock:    types.SystemClock{},
+		failures: max(failures, 1),
+		cooldown: cooldown,
+	}
+}
+
+// Middleware wraps a Loader so that all its calls go through the breaker.
+func (b *Breaker) Middleware(next types.Loader) types.Loader {
+	return wrap(next, b.around)
+}
+
+// around runs one call through the breaker: rejected while open, and counted once done.
+func (b *Breaker) around(ctx context.Context, call func(ctx context.Context) error) error {
+	probe, ok := b.allow()
+	if !ok {
+		return ErrBreakerOpen
+	}
+	err := call(ctx)
+	b.record(probe, err)
+	return err
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() types.BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+/*
+allow decides whether a call may go through, moving from open to half-open once the cooldown is over.
+probe is set for the half-open trial call; its outcome decides the next state.
+*/
+func (b *Breaker) allow() (probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case types.BreakerOpen:
+		if b.Clock.Now().Sub(b.openedAt) < b.cooldown {
+			return false, false
+		}
+		b.setState(types.BreakerHalfOpen)
+		b.probing = true
+		return true, true
+	case types.BreakerHalfOpen:
+		// Only one trial call at a time
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// record updates the breaker with the outcome of a call that was allowed through; probe is what allow returned.
+func (b *Breaker) record(probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	// The caller gave up; that says nothing about the backing store
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	// A slow call let through before the breaker opened has no say once it is open or half-open
+	if !probe && b.state != types.BreakerClosed {
+		return
+	}
+
+	if err == nil {
+		b.failed = 0
+		if probe {
+			b.setState(types.BreakerClosed)
+		}
+		return
+	}
+
+	b.failed++
+	if probe || b.failed >= b.failures {
+		b.failed = 0
+		b.openedAt = b.Clock.Now()
+		b.setState(types.BreakerOpen)
+	}
+}
+
+// setState changes the state and reports it. It must be called with b.mu held.
+func (b *Breaker) setState(state types.BreakerState) {
+	b.state = state
+	if m, ok := b.Metrics.(types.BreakerMetrics); ok {
+		m.BreakerStateChanged(b.Name, state)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Package loader provides composable middleware for types.Loader.
+
+Every backing store needs the same resilience around it: retries, timeouts,
+a circuit breaker. Instead of wrapping each Loader by hand, wrap it once:
+
+	l := loader.Chain(db,
+		loader.Retry(3, loader.Backoff{Initial: 50 * time.Millisecond, Max: time.Second, Jitter: 0.5}),
+		breaker.Middleware,
+		loader.Timeout(200*time.Millisecond),
+	)
+
+Each middleware applies to every call: Load and Put, and Delete, LoadAll and PutAll.
+A wrapped loader has exactly the optional capabilities (types.Deleter, types.BatchLoader,
+types.BatchPutter) of the loader it wraps.
+*/
+package loader
+
+import (
+	"context"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+// Middleware wraps a Loader with extra behavior.
+type Middleware func(next types.Loader) types.Loader
+
+/*
+Chain wraps l with the given middleware.
+The first middleware is the outermost: it sees each call first.
+*/
+func Chain(l types.Loader, mws ...Middleware) types.Loader {
+	for i := len(mws) - 1; i >= 0; i-- {
+		l = mws[i](l)
+	}
+	return l
+}
+
+/*
+around runs one call to the wrapped loader, with a middleware's behavior around it.
+call must be run with the ctx it is given, which may differ from the caller's (Timeout).
+*/
+type around func(ctx context.Context, call func(ctx context.Context) error) error
+
+// wrapped runs every call to next through around.
+type wrapped struct {
+	next   types.Loader
+	around around
+}
+
+/*
+wrap builds the Loader returned by a middleware.
+
+Go cannot add methods to a value at runtime, so each combination of optional
+capabilities of next gets its own type, built from the pieces below.
+*/
+func wrap(next types.Loader, around around) types.Loader {
+	w := &wrapped{next: next, around: around}
+
+	_, del := next.(types.Deleter)
+	_, bl := next.(types.BatchLoader)
+	_, bp := next.(types.BatchPutter)
+
+	switch {
+	case del && bl && bp:
+		return struct {
+			*wrapped
+			deleter
+			batchLoader
+			batchPutter
+		}{w, deleter{w}, batchLoader{w}, batchPutter{w}}
+	case del && bl:
+		return struct {
+			*wrapped
+			deleter
+			batchLoader
+		}{w, deleter{w}, batchLoader{w}}
+	case del && bp:
+		return struct {
+			*wrapped
+			deleter
+			batchPutter
+		}{w, deleter{w}, batchPutter{w}}
+	case bl && bp:
+		return struct {
+			*wrapped
+			batchLoader
+			batchPutter
+		}{w, batchLoader{w}, batchPutter{w}}
+	case del:
+		return struct {
+			*wrapped
+			deleter
+		}{w, deleter{w}}
+	case bl:
+		return struct {
+			*wrapped
+			batchLoader
+		}{w, batchLoader{w}}
+	case bp:
+		return struct {
+			*wrapped
+			batchPutter
+		}{w, batchPutter{w}}
+	default:
+		return w
+	}
+}
+
+func (w *wrapped) Load(ctx context.Context, key string) (any, error) {
+	var val any
+	err := w.around(ctx, func(ctx context.Context) error {
+		var err error
+		val, err = w.next.Load(ctx, key)
+		return err
+	})
+	return val, err
+}
+
+func (w *wrapped) Put(ctx context.Context, key string, value any) error {
+	return w.around(ctx, func(ctx context.Context) error {
+		return w.next.Put(ctx, key, value)
+	})
+}
+
+// deleter exposes types.Deleter, for wrapped loaders that have it.
+type deleter struct{ w *wrapped }
+
+func (d deleter) Delete(ctx context.Context, key string) error {
+	return d.w.around(ctx, func(ctx context.Context) error {
+		return d.w.next.(types.Deleter).Delete(ctx, key)
+	})
+}
+
+// batchLoader exposes types.BatchLoader, for wrapped loaders that have it.
+type batchLoader struct{ w *wrapped }
+
+func (b batchLoader) LoadAll(ctx context.Context, keys []string) (map[string]any, error) {
+	var vals map[string]any
+	err := b.w.around(ctx, func(ctx context.Context) error {
+		var err error
+		vals, err = b.w.next.(types.BatchLoader).LoadAll(ctx, keys)
+		return err
+	})
+	return vals, err
+}
+
+// batchPutter exposes types.BatchPutter, for wrapped loaders that have it.
+type batchPutter struct{ w *wrapped }
+
+func (b batchPutter) PutAll(ctx context.Context, values map[string]any) error {
+	return b.w.around(ctx, func(ctx context.Context) error {
+		return b.w.next.(types.BatchPutter).PutAll(ctx, values)
+	})
+}
@@ -0,0 +1,86 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+Backoff computes how long to wait before retrying a failed call.
+
+The delay grows exponentially: Initial, Initial*Multiplier, Initial*Multiplier², ...
+capped at Max. Jitter (0..1) randomly shortens each delay by up to that fraction,
+so many callers failing at once do not all retry at the same moment.
+*/
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration // zero means no cap
+	Multiplier float64       // defaults to 2
+	Jitter     float64
+}
+
+// Delay returns the wait before retry number attempt (0 for the first retry).
+func (b Backoff) Delay(attempt int) time.Duration {
+	mult := b.Multiplier
+	if mult <= 0 {
+		mult = 2
+	}
+
+	d := float64(b.Initial) * math.Pow(mult, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d -= d * min(b.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+/*
+Retry retries failed calls, up to attempts calls in total, waiting between them as backoff says.
+
+It gives up early when the ctx is done, and does not retry calls
+rejected by an open circuit breaker (ErrBreakerOpen).
+*/
+func Retry(attempts int, backoff Backoff) Middleware {
+	return func(next types.Loader) types.Loader {
+		r := &retrying{attempts: max(attempts, 1), backoff: backoff}
+		return wrap(next, r.around)
+	}
+}
+
+type retrying struct {
+	attempts int
+	backoff  Backoff
+}
+
+func (r *retrying) around(ctx context.Context, call func(ctx context.Context) error) error {
+	return r.do(ctx, func() error { return call(ctx) })
+}
+
+// do runs call until it succeeds, the attempts run out, or retrying is pointless.
+func (r *retrying) do(ctx context.Context, call func() error) error {
+	var err error
+	for attempt := 0; attempt < r.attempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(r.backoff.Delay(attempt - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+
+		err = call()
+		if err == nil || ctx.Err() != nil || errors.Is(err, ErrBreakerOpen) {
+			return err
+		}
+	}
+	return err
+}
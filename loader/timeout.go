@@ -0,0 +1,24 @@
+package loader
+
+import (
+	"context"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+Timeout gives every call its own deadline.
+
+Placed inside Retry, it bounds each attempt rather than the whole retried call,
+so one hung attempt does not use up the caller's entire budget.
+*/
+func Timeout(d time.Duration) Middleware {
+	return func(next types.Loader) types.Loader {
+		return wrap(next, func(ctx context.Context, call func(ctx context.Context) error) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return call(ctx)
+		})
+	}
+}
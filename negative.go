@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+/*
+negativeCache remembers keys that the Loader could not find.
+
+Without it, every lookup of a non-existent key goes to the backing store,
+which is an easy way to hammer it. Entries live for a short TTL and the
+number of remembered keys is bounded; when full, the oldest key is dropped first.
+*/
+type negativeCache struct {
+	ttl     time.Duration
+	maxKeys int
+
+	mu    sync.RWMutex
+	keys  map[string]*list.Element
+	order *list.List // oldest at the front
+}
+
+// negativeEntry is one remembered missing key.
+type negativeEntry struct {
+	key      string
+	expireAt time.Time
+}
+
+func newNegativeCache(ttl time.Duration, maxKeys int) *negativeCache {
+	return &negativeCache{
+		ttl:     ttl,
+		maxKeys: max(maxKeys, 1),
+		keys:    make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// has reports whether key is known to be missing at time now.
+func (n *negativeCache) has(key string, now time.Time) bool {
+	n.mu.RLock()
+	el, ok := n.keys[key]
+	live := ok && now.Before(el.Value.(*negativeEntry).expireAt)
+	n.mu.RUnlock()
+
+	if ok && !live {
+		n.removeExpired(key, now)
+	}
+	return live
+}
+
+// removeExpired forgets key if it is still expired at time now.
+// The expiry is checked again under the write lock: an add may have re-armed the key since.
+func (n *negativeCache) removeExpired(key string, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if el, ok := n.keys[key]; ok && !now.Before(el.Value.(*negativeEntry).expireAt) {
+		n.order.Remove(el)
+		delete(n.keys, key)
+	}
+}
+
+// add remembers key as missing until now + ttl.
+func (n *negativeCache) add(key string, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if el, ok := n.keys[key]; ok {
+		el.Value.(*negativeEntry).expireAt = now.Add(n.ttl)
+		n.order.MoveToBack(el)
+		return
+	}
+
+	for n.order.Len() >= n.maxKeys {
+		oldest := n.order.Front()
+		n.order.Remove(oldest)
+		delete(n.keys, oldest.Value.(*negativeEntry).key)
+	}
+	n.keys[key] = n.order.PushBack(&negativeEntry{key, now.Add(n.ttl)})
+}
+
+// remove forgets key, for example because a value was just written for it.
+func (n *negativeCache) remove(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if el, ok := n.keys[key]; ok {
+		n.order.Remove(el)
+		delete(n.keys, key)
+	}
+}
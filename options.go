@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/expiration"
+	"github.com/krisalay/in-memory-cache/shard"
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+Option configures optional ShardedCache behavior.
+Options are passed to NewShardedCache after the required arguments,
+so existing callers keep working unchanged.
+*/
+type Option func(*ShardedCache)
+
+// WithStore selects the ShardStore implementation used by every shard.
+// Defaults to shard.COW.
+func WithStore(t shard.StoreType) Option {
+	return func(c *ShardedCache) {
+		c.storeType = t
+	}
+}
+
+// WithWeigher makes capacity weight-based instead of entry-based.
+// Each entry costs weigher(key, value) and the whole cache holds at most maxWeight,
+// divided evenly across shards; if maxWeight is below the shard count, fewer shards are used.
+// The capacity argument of NewShardedCache is ignored.
+func WithWeigher(weigher types.Weigher, maxWeight int64) Option {
+	return func(c *ShardedCache) {
+		c.weigher = weigher
+		c.maxWeight = maxWeight
+	}
+}
+
+// WithRemovalListener registers a callback that is told about every entry the cache drops,
+// along with the cause (evicted, expired, explicit or replaced).
+func WithRemovalListener(listener types.RemovalListener) Option {
+	return func(c *ShardedCache) {
+		c.listener = listener
+	}
+}
+
+// WithActiveExpiration starts a background sweeper that removes expired entries
+// even if they are never read again. Every interval it samples TTL'd entries per shard
+// and spends at most budget on each cycle. It is stopped by Close.
+// A non-positive interval disables the sweeper.
+func WithActiveExpiration(interval, budget time.Duration) Option {
+	return func(c *ShardedCache) {
+		if interval <= 0 {
+			c.sweeper = nil
+			return
+		}
+		c.sweeper = &sweeper{interval: interval, budget: budget}
+	}
+}
+
+// WithTimingWheel tracks every TTL'd entry on a hierarchical timing wheel with the given tick,
+// so expired entries are removed close to their deadline instead of on the next read.
+// The wheel is stopped by Close.
+func WithTimingWheel(tick time.Duration) Option {
+	return func(c *ShardedCache) {
+		c.wheelTick = tick
+	}
+}
+
+// WithExpiry computes each entry's TTL from its key and value on create, update and read.
+// It applies to Put and read-through loads; explicit TTLs from PutWithTTL take precedence.
+func WithExpiry(expiry expiration.Expiry) Option {
+	return func(c *ShardedCache) {
+		c.expiry = expiry
+	}
+}
+
+/*
+WithEarlyExpiration enables XFetch probabilistic early expiration for loaded entries.
+
+A reader may treat an entry as expired shortly before its ExpireAt and reload it,
+with a probability based on how long the value took to load. This avoids the latency
+spike of every reader missing at the same moment. beta scales how early this happens;
+1.0 is the usual choice. If the early reload fails or finds nothing, that reader still
+gets the cached value, since it has not expired yet.
+*/
+func WithEarlyExpiration(beta float64) Option {
+	return func(c *ShardedCache) {
+		c.earlyBeta = beta
+	}
+}
+
+/*
+WithStaleIfError keeps expired entries for a grace window after they expire.
+
+If Get finds such a stale entry and the Loader fails to reload it, the stale value
+is returned along with an error wrapping ErrStale and the load error, and
+Metrics.Stale is called (types.StaleMetrics). Once the grace window has passed, the entry is removed as usual.
+*/
+func WithStaleIfError(grace time.Duration) Option {
+	return func(c *ShardedCache) {
+		c.staleGrace = grace
+	}
+}
+
+/*
+WithNegativeCache remembers keys that the Loader could not find (returned nil),
+so repeated lookups of missing keys stop reaching the backing store.
+
+A missing key is remembered for ttl, and at most maxKeys missing keys are remembered,
+dropping the oldest first. Get returns ErrNotFound for such keys. Writing the key
+(Put, PutWithTTL) or removing it forgets it right away.
+*/
+func WithNegativeCache(ttl time.Duration, maxKeys int) Option {
+	return func(c *ShardedCache) {
+		c.negative = newNegativeCache(ttl, maxKeys)
+	}
+}
+
+/*
+WithLoadBatching groups concurrent Get misses on different keys into batch loads (the DataLoader pattern).
+
+Misses are collected for up to maxWait, or until maxBatch keys are waiting, and then
+loaded together with one call. This pays off when the Loader is a types.BatchLoader;
+otherwise the keys of a batch are still loaded one by one. A key that fails to load
+only fails its own callers. Each miss waits up to maxWait longer, so keep it short
+(a few milliseconds).
+*/
+func WithLoadBatching(maxWait time.Duration, maxBatch int) Option {
+	return func(c *ShardedCache) {
+		c.batcher = newBatcher(maxWait, maxBatch, func(ctx context.Context, keys []string) (map[string]any, error) {
+			return c.engine.LoadAll(ctx, keys)
+		})
+	}
+}
@@ -4,7 +4,12 @@
 
 package refresh
 
-import "github.com/krisalay/in-memory-cache/types"
+import (
+	"context"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
 
 /*
 Hook is the interface for refresh behavior.
@@ -28,3 +33,34 @@ type Hook interface {
 	*/
 	OnRead(key string, ent *types.CacheEntry)
 }
+
+/*
+Binding connects a refresh hook to the cache it serves.
+
+OnRead only receives the key and entry. Hooks that reload data also need to reach
+the backing store and write the result back into the cache; Binding provides that.
+*/
+type Binding struct {
+
+	// Loader is the cache's backing store.
+	Loader types.Loader
+
+	// Metrics is where the hook reports refreshes.
+	Metrics types.Metrics
+
+	// Clock is the cache's clock, for age checks.
+	Clock types.Clock
+
+	// Store swaps a reloaded value into the cache. ttl is a TTL chosen by the loader, or 0.
+	// It reports whether the value was stored. It is dropped if the key was removed or expired
+	// during the reload, or has a write the backing store does not have yet.
+	Store func(ctx context.Context, key string, value any, ttl time.Duration) bool
+}
+
+/*
+Binder is an optional extension of Hook.
+If the configured hook implements it, the cache calls Bind once when it is created.
+*/
+type Binder interface {
+	Bind(Binding)
+}
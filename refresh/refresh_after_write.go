@@ -0,0 +1,135 @@
+package refresh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+RefreshAfterWrite reloads entries in the background once they are older than a threshold.
+
+Flow on a cache hit:
+--------------------
+ 1. The entry is younger than the threshold → nothing happens
+ 2. The entry is older → the CURRENT value is still returned to the reader,
+    and ONE background Loader.Load is started for that key
+ 3. When the load succeeds, the new value is swapped into the cache
+
+Hot keys are therefore reloaded before they expire, and readers never wait for the backing store.
+
+Guarantees:
+-----------
+- At most one reload per key at a time (deduplicated)
+- At most maxConcurrent reloads overall; extra triggers are skipped and retried on a later read
+- Metrics.Refresh() is reported only when a reload actually replaced the value
+*/
+type RefreshAfterWrite struct {
+
+	// threshold is the age after which an entry is reloaded.
+	threshold time.Duration
+
+	// sem bounds the number of concurrent reloads.
+	sem chan struct{}
+
+	// mu protects inflight.
+	mu sync.Mutex
+
+	// inflight holds the keys currently being reloaded.
+	inflight map[string]struct{}
+
+	// b connects the hook to its cache. Set by Bind.
+	b Binding
+
+	// wg waits for running reloads during Close.
+	wg sync.WaitGroup
+}
+
+// NewRefreshAfterWrite creates a refresh-ahead hook.
+// Entries older than threshold are reloaded, with at most maxConcurrent loads at once.
+func NewRefreshAfterWrite(threshold time.Duration, maxConcurrent int) *RefreshAfterWrite {
+	return &RefreshAfterWrite{
+		threshold: threshold,
+		sem:       make(chan struct{}, max(maxConcurrent, 1)),
+		inflight:  make(map[string]struct{}),
+	}
+}
+
+// Bind is called by the cache when it is created.
+func (r *RefreshAfterWrite) Bind(b Binding) {
+	r.b = b
+}
+
+// OnRead checks the entry's age and starts a background reload if it is due.
+// It never blocks: if the key is already reloading or the concurrency limit is reached, it returns.
+func (r *RefreshAfterWrite) OnRead(key string, ent *types.CacheEntry) {
+	if r.b.Loader == nil {
+		// Not bound to a cache
+		return
+	}
+
+	if r.b.Clock.Now().Sub(ent.CreatedAt) < r.threshold {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.inflight[key]; ok {
+		return
+	}
+
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		// At capacity; a later read will try again
+		return
+	}
+
+	r.inflight[key] = struct{}{}
+	r.wg.Add(1)
+	go r.reload(key, ent.Reload)
+}
+
+/*
+reload fetches a fresh value and swaps it into the cache.
+Keys cached by GetOrLoad are reloaded with their own loader (load), the others with the cache's Loader.
+Failed or empty loads keep the current value; it stays valid until it expires.
+*/
+func (r *RefreshAfterWrite) reload(key string, load func(context.Context) (any, time.Duration, error)) {
+	defer func() {
+		r.mu.Lock()
+		delete(r.inflight, key)
+		r.mu.Unlock()
+
+		<-r.sem
+		r.wg.Done()
+	}()
+
+	ctx := context.Background()
+
+	var (
+		val any
+		ttl time.Duration
+		err error
+	)
+	if load != nil {
+		val, ttl, err = load(ctx)
+	} else {
+		val, err = r.b.Loader.Load(ctx, key)
+	}
+	if err != nil || val == nil {
+		return
+	}
+
+	if r.b.Store(ctx, key, val, ttl) {
+		r.b.Metrics.Refresh()
+	}
+}
+
+// Close waits for running reloads to finish. The cache calls it during shutdown.
+func (r *RefreshAfterWrite) Close() {
+	r.wg.Wait()
+}
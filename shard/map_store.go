@@ -0,0 +1,71 @@
+package shard
+
+import (
+	"sync"
+
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+mapStore is a mutable-map implementation of ShardStore.
+
+Unlike cowStore, writes update the map in place:
+- Readers take a read lock
+- Writers take a write lock and touch only the changed key
+
+This trades lock-free reads for writes that cost O(1) instead of O(n).
+It is the better choice for large shards or write-heavy workloads, where
+copying the whole map on every Put/Delete dominates CPU time.
+*/
+type mapStore struct {
+
+	// mu protects data. Many readers may hold it at once.
+	mu sync.RWMutex
+
+	// data holds the actual key → entry mapping.
+	data map[string]*types.CacheEntry
+}
+
+func NewMapStore() *mapStore {
+	return &mapStore{data: make(map[string]*types.CacheEntry)}
+}
+
+// Get retrieves an entry from the store.
+func (s *mapStore) Get(key string) (*types.CacheEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ent, ok := s.data[key]
+	return ent, ok
+}
+
+// Put inserts or replaces an entry in place.
+func (s *mapStore) Put(key string, ent *types.CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = ent
+}
+
+// Delete removes an entry in place.
+func (s *mapStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+}
+
+// Size returns how many entries are in the store.
+func (s *mapStore) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.data))
+}
+
+// Range iterates while holding the read lock, so fn must not call back into the store.
+func (s *mapStore) Range(fn func(string, *types.CacheEntry) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for k, v := range s.data {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
@@ -17,10 +17,14 @@ We split the cache into many shards. Each shard:
 This dramatically improves concurrency and scalability.
 */
 
+// readBufferSize is how many hits a shard buffers before they are replayed into its eviction policy.
+const readBufferSize = 64
+
 type Shard struct {
 
-	// Store holds the actual key → value data for this shard. This is NOT a regular map.
-	// It is a copy-on-write store that allows lock-free reads.
+	// Store holds the actual key → value data for this shard.
+	// By default it is a copy-on-write store that allows lock-free reads,
+	// but any ShardStore implementation can be plugged in.
 	Store ShardStore
 
 	// Eviction controls which key should be removed when this shard runs out of space.
@@ -33,11 +37,94 @@ type Shard struct {
 	//
 	// This is a deliberate design choice: reads are much more frequent than writes.
 	EvictMu sync.Mutex
+
+	// Weight is the total weight of all entries stored in this shard.
+	// It is protected by EvictMu.
+	Weight int64
+
+	// reads buffers hits until they are replayed into Eviction under EvictMu.
+	// Eviction policies are not safe for concurrent use, and reads do not take the lock.
+	reads chan string
+
+	// turns holds, for each key with a write on its way to the write policy, the latest one's
+	// done channel (see WriteTurn). It is protected by EvictMu.
+	turns map[string]chan struct{}
 }
 
-func NewShard(ev eviction.Policy) *Shard {
+func NewShard(ev eviction.Policy, store ShardStore) *Shard {
 	return &Shard{
-		Store:    NewCOWStore(),
+		Store:    store,
 		Eviction: ev,
+		reads:    make(chan string, readBufferSize),
+		turns:    make(map[string]chan struct{}),
+	}
+}
+
+/*
+RecordRead tells the eviction policy about a hit, without making the read wait for EvictMu.
+
+The hit is buffered, and replayed by the next writer (DrainReads).
+When the buffer is full, the reader replays it itself if the lock is free.
+Otherwise the hit is dropped: eviction order is a heuristic, losing a few hits under heavy
+contention is fine (Caffeine's read buffers make the same trade-off).
+*/
+func (s *Shard) RecordRead(key string) {
+	select {
+	case s.reads <- key:
+		return
+	default:
+	}
+
+	if s.EvictMu.TryLock() {
+		s.DrainReads()
+		s.Eviction.OnGet(key)
+		s.EvictMu.Unlock()
+	}
+}
+
+// DrainReads replays buffered hits into the eviction policy, oldest first.
+// It must be called with EvictMu held, before the policy is used.
+func (s *Shard) DrainReads() {
+	for {
+		select {
+		case key := <-s.reads:
+			s.Eviction.OnGet(key)
+		default:
+			return
+		}
+	}
+}
+
+// noTurn is a closed channel: the turn of a key with no write ahead of it.
+var noTurn = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+/*
+WriteTurn lines a write up behind the previous writes of key that are still on their way to the write policy.
+
+The write policy is called outside EvictMu, since it may block. WriteTurn is called with
+EvictMu held, right when the write is applied to the store, so the writes of a key reach
+the write policy in the order they were applied. The caller waits for wait, hands the
+write to the policy, then calls done to let the next write of the key go.
+Writes of other keys are not held up.
+*/
+func (s *Shard) WriteTurn(key string) (wait <-chan struct{}, done func()) {
+	prev, ok := s.turns[key]
+	if !ok {
+		prev = noTurn
+	}
+	ch := make(chan struct{})
+	s.turns[key] = ch
+
+	return prev, func() {
+		s.EvictMu.Lock()
+		if s.turns[key] == ch {
+			delete(s.turns, key)
+		}
+		s.EvictMu.Unlock()
+		close(ch)
 	}
 }
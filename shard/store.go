@@ -29,6 +29,36 @@ type ShardStore interface {
 
 	// Size returns how many entries are stored.
 	Size() int64
+
+	// Range calls fn for entries in the store until fn returns false.
+	// The order is unspecified and fn must not modify the store.
+	Range(fn func(string, *types.CacheEntry) bool)
+}
+
+// StoreType is a simple identifier for supported ShardStore implementations.
+type StoreType string
+
+const (
+	// COW (Copy-On-Write): lock-free reads, every write copies the whole shard map.
+	// Best for small, read-mostly shards.
+	COW StoreType = "COW"
+
+	// Mutable: RWMutex-protected map updated in place.
+	// Best for large or write-heavy shards.
+	Mutable StoreType = "Mutable"
+)
+
+// NewShardStore is a small factory function.
+// Given a StoreType, it creates the correct ShardStore.
+func NewShardStore(t StoreType) ShardStore {
+	switch t {
+	case COW:
+		return NewCOWStore()
+	case Mutable:
+		return NewMapStore()
+	default:
+		panic("unknown shard store")
+	}
 }
 
 /*
@@ -127,3 +157,13 @@ func (s *cowStore) Delete(key string) {
 func (s *cowStore) Size() int64 {
 	return s.size.Load()
 }
+
+// Range iterates over the current snapshot. Writes during iteration are not seen.
+func (s *cowStore) Range(fn func(string, *types.CacheEntry) bool) {
+	m := s.data.Load().(map[string]*types.CacheEntry)
+	for k, v := range m {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
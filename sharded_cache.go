@@ -2,13 +2,18 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"time"
 
 	"github.com/krisalay/in-memory-cache/engine"
 	evict "github.com/krisalay/in-memory-cache/eviction"
+	"github.com/krisalay/in-memory-cache/expiration"
+	"github.com/krisalay/in-memory-cache/refresh"
 	"github.com/krisalay/in-memory-cache/shard"
 	"github.com/krisalay/in-memory-cache/types"
-	"golang.org/x/sync/singleflight"
 )
 
 /*
@@ -34,8 +39,47 @@ type ShardedCache struct {
 	// capacity is the maximum number of entries in the cache. This is divided across shards.
 	capacity int
 
-	// singleflight prevents multiple goroutines from loading the same key from the backing store simultaneously.
-	sf singleflight.Group
+	// weigher computes the cost of each entry. If nil, every entry costs 1.
+	weigher types.Weigher
+
+	// maxWeight is the total weight budget when a weigher is configured.
+	maxWeight int64
+
+	// shardBudget is the maximum total weight of a single shard.
+	shardBudget int64
+
+	// storeType decides which ShardStore implementation each shard uses.
+	storeType shard.StoreType
+
+	// listener is notified about every entry that leaves the cache. Optional.
+	listener types.RemovalListener
+
+	// sweeper actively removes expired entries in the background. Optional.
+	sweeper *sweeper
+
+	// batcher groups concurrent misses on different keys into one batch load. Optional.
+	batcher *batcher
+
+	// negative remembers keys the Loader could not find. Optional.
+	negative *negativeCache
+
+	// staleGrace is how long expired entries are kept to be served if a reload fails. Optional.
+	staleGrace time.Duration
+
+	// earlyBeta enables XFetch early expiration when > 0. Higher values refresh earlier.
+	earlyBeta float64
+
+	// expiry computes per-entry TTLs from the key and value. Optional.
+	expiry expiration.Expiry
+
+	// wheelTick is the resolution of the timing wheel; zero disables it.
+	wheelTick time.Duration
+
+	// wheel schedules the removal of every TTL'd entry at its deadline. Optional.
+	wheel *expiration.TimingWheel
+
+	// sf prevents multiple goroutines from loading the same key from the backing store simultaneously.
+	sf loadGroup
 }
 
 func NewShardedCache(
@@ -43,72 +87,348 @@ func NewShardedCache(
 	capacity int,
 	eviction evict.PolicyType,
 	engine *engine.CacheEngine,
+	opts ...Option,
 ) *ShardedCache {
 
+	c := &ShardedCache{
+		engine:    engine,
+		selector:  &shard.PowerOfTwoSelector{}, // smart shard selection
+		capacity:  capacity,
+		storeType: shard.COW,
+	}
+
+	// Apply optional configuration
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	/*
+		Total capacity is divided across shards.
+		Without a weigher, every entry weighs 1, so the budget is an entry count.
+		Every shard needs a budget of at least 1, so a small cache gets fewer shards
+		rather than more room than it was given.
+	*/
+	total := int64(capacity)
+	if c.weigher != nil {
+		total = c.maxWeight
+	}
+	shards = int(min(int64(shards), max(total, 1)))
+	c.shardBudget = max(total/int64(shards), 1)
+
 	// Create shards
-	s := make([]*shard.Shard, shards)
-	for i := range s {
-		// Each shard gets its own eviction policy instance
-		s[i] = shard.NewShard(evict.NewEvictionPolicy(eviction))
+	c.shards = make([]*shard.Shard, shards)
+	for i := range c.shards {
+		// Each shard gets its own eviction policy and store instance
+		c.shards[i] = shard.NewShard(
+			evict.NewEvictionPolicy(eviction),
+			shard.NewShardStore(c.storeType),
+		)
 	}
 
-	return &ShardedCache{
-		shards:   s,
-		engine:   engine,
-		selector: &shard.PowerOfTwoSelector{}, // smart shard selection
-		capacity: capacity,
+	// Give refresh hooks a way to reload entries and write them back
+	if b, ok := engine.Refresh.(refresh.Binder); ok {
+		b.Bind(refresh.Binding{
+			Loader:  engine.Loader,
+			Metrics: engine.Metrics,
+			Clock:   engine.Clock,
+			Store:   c.refreshed,
+		})
 	}
+
+	// Start background work only once the shards exist
+	if c.wheelTick > 0 {
+		c.wheel = expiration.NewTimingWheel(c.wheelTick, engine.Clock, c.onDeadline)
+	}
+	if c.sweeper != nil {
+		c.sweeper.start(c)
+	}
+
+	return c
 }
 
 /*
 Get retrieves a value from the cache.
+On a miss, the value is loaded with the engine's Loader,
+batched together with other misses if batching is enabled.
 */
 func (c *ShardedCache) Get(ctx context.Context, key string) (any, error) {
+	return c.getOrLoad(ctx, key, func(ctx context.Context) (any, time.Duration, error) {
+		if c.batcher != nil {
+			val, err := c.batcher.Load(ctx, key)
+			return val, 0, err
+		}
+		val, err := c.engine.Load(ctx, key)
+		return val, 0, err
+	}, false)
+}
 
-	// Decide which shard should handle this key
-	sh := c.selector.Select(key, c.shards)
+/*
+GetOrLoad retrieves a value from the cache, loading it with load on a miss.
 
-	// Try to read from shard storage
-	if ent, ok := sh.Store.Get(key); ok {
+This lets one cache serve keys that come from different sources. load may
+also return a TTL for the value; zero means the cache's usual expiration applies.
+Concurrent misses on the same key are deduplicated exactly like Get.
 
-		// Check if entry is expired
-		if c.engine.IsExpired(ent) {
-			c.engine.Metrics.Expire()
-			c.Remove(key) // remove expired entry
-		} else {
-			// Cache hit
-			c.engine.Metrics.Hit()
+The entry remembers load: a refresh hook reloads the key with it, not with the engine's Loader.
+*/
+func (c *ShardedCache) GetOrLoad(
+	ctx context.Context,
+	key string,
+	load func(ctx context.Context) (any, time.Duration, error),
+) (any, error) {
+	return c.getOrLoad(ctx, key, load, true)
+}
 
-			// Update TTL / refresh logic
-			c.engine.OnRead(key, ent)
+// getOrLoad implements Get and GetOrLoad. perCall says whether load must be remembered for refreshes.
+func (c *ShardedCache) getOrLoad(
+	ctx context.Context,
+	key string,
+	load func(ctx context.Context) (any, time.Duration, error),
+	perCall bool,
+) (any, error) {
 
-			// Update eviction metadata
-			sh.Eviction.OnGet(key)
+	// Try to read from shard storage
+	val, stale, hit := c.lookup(key)
+	if hit {
+		return val, nil
+	}
 
-			return ent.Value, nil
-		}
+	// Known to be missing from the backing store
+	if c.isNegative(key) {
+		return nil, ErrNotFound
 	}
 
 	// Cache miss
 	c.engine.Metrics.Miss()
 
 	/*
-		singleflight ensures that:
+		The load group ensures that:
 		- If 100 goroutines request the same missing key,
 		  only ONE of them loads it from the backing store.
 		- Others wait for the result.
+		- Each caller stops waiting when its own ctx is done; the load
+		  itself is only cancelled once every waiting caller has gone.
 	*/
-	val, err, _ := c.sf.Do(key, func() (any, error) {
-		return c.engine.Load(ctx, key)
+	res, err := c.sf.Do(ctx, key, func(ctx context.Context) (res loaded, err error) {
+		// The backing store does not have the writes the write policy is still holding back
+		res, pending := c.pending(key)
+		if !pending {
+			start := c.engine.Now()
+			res.value, res.ttl, err = load(ctx)
+			res.took = c.engine.Now().Sub(start)
+		}
+		if perCall {
+			res.reload = load
+		}
+		return res, err
 	})
-	if err != nil || val == nil {
+
+	// This caller gave up; the load may still finish for the others
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return c.finishLoad(ctx, key, res, err, stale)
+}
+
+/*
+GetAll retrieves several values at once.
+
+Hits are served from memory. All missing keys are loaded together: with a single
+LoadAll round trip if the Loader is a types.BatchLoader, or one Load per key otherwise.
+Keys already being loaded by another caller are waited for, not loaded twice.
+
+Keys that do not exist in the backing store are left out of the result.
+If some loads fail, the values that could be served are returned along with the first error.
+*/
+func (c *ShardedCache) GetAll(ctx context.Context, keys []string) (map[string]any, error) {
+	found := make(map[string]any, len(keys))
+	stale := make(map[string]*types.CacheEntry)
+	var missing []string
+
+	for _, key := range keys {
+		if _, seen := found[key]; seen {
+			continue
+		}
+		val, ent, hit := c.lookup(key)
+		if hit {
+			found[key] = val
+			continue
+		}
+		if c.isNegative(key) {
+			continue
+		}
+		c.engine.Metrics.Miss()
+		if ent != nil {
+			stale[key] = ent
+		}
+		missing = append(missing, key)
+	}
+
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	flights, err := c.sf.DoBatch(ctx, missing, func(ctx context.Context, keys []string) (map[string]loaded, error) {
+		res := make(map[string]loaded, len(keys))
+		var load []string
+		for _, key := range keys {
+			// Served from the write policy, like in Get; a pending removal is left out as not found
+			if r, pending := c.pending(key); pending {
+				if r.value != nil {
+					res[key] = r
+				}
+				continue
+			}
+			load = append(load, key)
+		}
+		if len(load) == 0 {
+			return res, nil
+		}
+
+		start := c.engine.Now()
+		vals, err := c.engine.LoadAll(ctx, load)
+		took := c.engine.Now().Sub(start)
+
+		for key, val := range vals {
+			res[key] = loaded{value: val, took: took}
+		}
+		return res, err
+	})
+	if err != nil {
+		return found, err
+	}
+
+	var firstErr error
+	for key, f := range flights {
+		val, err := c.finishLoad(ctx, key, f.res, f.err, stale[key])
+		if val != nil {
+			found[key] = val
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return found, firstErr
+}
+
+/*
+lookup reads a key from memory.
+
+On a hit it returns the value. On a miss it returns the entry to fall back on if the
+load fails: the expired entry kept as stale (stale-if-error), or the live entry XFetch
+picked for an early refresh.
+*/
+func (c *ShardedCache) lookup(key string) (val any, stale *types.CacheEntry, hit bool) {
+
+	// Decide which shard should handle this key
+	sh := c.selector.Select(key, c.shards)
+
+	ent, ok := sh.Store.Get(key)
+	if !ok {
+		return nil, nil, false
+	}
+
+	// Check if entry is expired
+	if c.isExpired(ent) {
+		if c.withinGrace(ent) {
+			return nil, ent, false
+		}
+		// remove expired entry, unless a concurrent write already replaced it
+		if c.removeEntry(key, ent, types.CauseExpired) {
+			c.engine.Metrics.Expire()
+		}
+		return nil, nil, false
+	}
+
+	// XFetch picked this reader to recompute the value early.
+	// The entry stays in place, so every other reader keeps hitting it.
+	if c.expiresEarly(ent) {
+		return nil, ent, false
+	}
+
+	// Cache hit
+	c.engine.Metrics.Hit()
+
+	// Update TTL / refresh logic
+	c.engine.OnRead(key, ent)
+	c.expireAfterRead(ent)
+
+	// Update eviction metadata (buffered: the policy is only touched under the shard lock)
+	sh.RecordRead(key)
+
+	return ent.Value, nil, true
+}
+
+/*
+finishLoad handles the result of a load: it caches the loaded value,
+or falls back to the stale entry if the load failed.
+*/
+func (c *ShardedCache) finishLoad(ctx context.Context, key string, res loaded, err error, stale *types.CacheEntry) (any, error) {
+
+	// An early refresh (XFetch) that failed or found nothing: the entry has not expired yet, so it is served as is
+	if (err != nil || res.value == nil) && stale != nil && !c.isExpired(stale) {
+		return stale.Value, nil
+	}
+
+	// Stale-if-error: the backing store failed, but the expired value is still within its grace window
+	if err != nil && stale != nil {
+		if m, ok := c.engine.Metrics.(types.StaleMetrics); ok {
+			m.Stale()
+		}
+		return stale.Value, fmt.Errorf("%w: %w", ErrStale, err)
+	}
+
+	// The key is gone from the backing store, so its stale copy is of no use anymore
+	if err == nil && res.value == nil && stale != nil {
+		if c.removeEntry(key, stale, types.CauseExpired) {
+			c.engine.Metrics.Expire()
+		}
+	}
+
+	if err != nil {
 		return nil, err
 	}
 
+	// Remember missing keys, so the next lookup does not reach the backing store
+	if res.value == nil {
+		if c.negative == nil {
+			return nil, nil
+		}
+		c.negative.add(key, c.engine.Now())
+		return nil, ErrNotFound
+	}
+
 	// Store loaded value in cache
-	_ = c.Put(ctx, key, val)
+	_, _ = c.put(ctx, key, res, putLoad)
+
+	return res.value, nil
+}
+
+/*
+pending returns the write of key that the write policy has not delivered yet, as if it was loaded.
+A pending removal loads as not found. ok is false if nothing is pending, and the key must be loaded.
+*/
+func (c *ShardedCache) pending(key string) (res loaded, ok bool) {
+	val, deleted, ok := c.engine.Pending(key)
+	if ok && !deleted {
+		res.value = val
+	}
+	return res, ok
+}
+
+// loaded is the result of one backing-store load, shared by all goroutines waiting for it.
+type loaded struct {
+	value any
+
+	// ttl is the TTL the loader chose for the value; zero means the usual expiration applies.
+	ttl time.Duration
 
-	return val, nil
+	// took is how long the load took. XFetch uses it as the recompute time.
+	took time.Duration
+
+	// reload is the per-call loader the value came from (GetOrLoad); nil means the engine's Loader.
+	reload func(ctx context.Context) (any, time.Duration, error)
 }
 
 /*
@@ -127,65 +447,291 @@ func (c *ShardedCache) PutWithTTL(
 	value any,
 	ttl time.Duration,
 ) error {
+	_, err := c.put(ctx, key, loaded{value: value, ttl: ttl}, putWrite)
+	return err
+}
+
+// putMode says where a value stored by put comes from.
+type putMode int
+
+const (
+	// putWrite is a write by the caller (Put, PutWithTTL). It goes through the write policy.
+	putWrite putMode = iota
+
+	// putLoad is a value just loaded from the backing store. The write policy is skipped,
+	// since writing a value back to where it was just read from is wasted work.
+	putLoad
+
+	// putRefresh is a value reloaded by a refresh hook. Like putLoad, but it only
+	// replaces an entry that is still cached, and keeps that entry's explicit deadline.
+	putRefresh
+)
+
+/*
+put stores a value, and reports whether it is now cached: a refresh of a key that is gone,
+or a new key refused by admission, is not.
+
+res.took is how long the value took to load, or 0 if it was not loaded.
+*/
+func (c *ShardedCache) put(ctx context.Context, key string, res loaded, mode putMode) (stored bool, err error) {
 
 	// Select shard
 	sh := c.selector.Select(key, c.shards)
 
-	// Lock shard for safe writes
+	// The key exists now
+	if c.negative != nil {
+		c.negative.remove(key)
+	}
+
+	// An entry heavier than the whole shard can never fit
+	weight := c.weigh(key, res.value)
+	if weight > c.shardBudget {
+		return false, ErrEntryTooLarge
+	}
+
+	/*
+		Removals are reported, and the write handed to the write policy, only after the shard lock
+		is released (defers run in reverse order). Both can take a while: write-through waits for
+		the backing store, write-back may wait for room in its queue (OverflowBlock).
+		Concurrent writes of the same key still reach the write policy in the order they were
+		applied: each takes its turn under the lock (Shard.WriteTurn).
+	*/
+	var removed []removal
+	var persist *types.CacheEntry
+	var wait <-chan struct{}
+	var done func()
+	defer func() {
+		if persist != nil {
+			<-wait
+			c.engine.Persist(ctx, persist)
+			done()
+		}
+		c.notify(removed)
+	}()
+
+	// Lock shard for safe writes, and bring the eviction policy up to date with recent hits
 	sh.EvictMu.Lock()
 	defer sh.EvictMu.Unlock()
+	sh.DrainReads()
+
+	now := c.engine.Now()
+	old, replacing := sh.Store.Get(key)
 
 	/*
-		Check capacity of this shard.
-		Total capacity is divided across shards.
+		A refresh must not bring back a key that was removed or expired while it was reloading.
+		The entry keeps its explicit deadline, unless the reload chose a new TTL.
 	*/
-	if sh.Store.Size() >= int64(c.capacity/len(c.shards)) {
-
-		// Evict one key using eviction policy
-		evicted := sh.Eviction.Evict()
-		if evicted != "" {
-			c.engine.Metrics.Eviction()
-			sh.Store.Delete(evicted)
+	if mode == putRefresh {
+		if !replacing || c.isExpired(old) {
+			return false, nil
+		}
+		if old.ExplicitTTL && res.ttl <= 0 {
+			res.ttl = old.ExpireAt().Sub(now)
 		}
 	}
 
+	// A Put over a key cached by GetOrLoad keeps refreshing it from the same source
+	if replacing && mode != putLoad && res.reload == nil {
+		res.reload = old.Reload
+	}
+	ttl := res.ttl
+
 	// Create cache entry
-	now := time.Now()
 	ent := &types.CacheEntry{
-		Key:            key,
-		Value:          value,
-		CreatedAt:      now,
-		LastAccessedAt: now,
+		Key:          key,
+		Value:        res.value,
+		CreatedAt:    now,
+		Weight:       weight,
+		LoadDuration: res.took,
+		Reload:       res.reload,
 	}
+	ent.SetLastAccessedAt(now)
 
 	// If TTL is provided, set expiration time
 	if ttl > 0 {
-		ent.ExpireAt = now.Add(ttl)
+		ent.SetExpireAt(now.Add(ttl))
+		ent.ExplicitTTL = true
+	}
+
+	// Replacing a key frees the weight of the old entry
+	if replacing {
+		sh.Weight -= old.Weight
+		removed = append(removed, removal{key, old.Value, types.CauseReplaced})
+	}
+
+	/*
+		Admission: some eviction policies may refuse a NEW key when the shard is full.
+		The write policy still sees the value; only the in-memory copy is skipped.
+	*/
+	if a, ok := sh.Eviction.(evict.Admitter); ok &&
+		!replacing && sh.Weight+weight > c.shardBudget && !a.Admit(key) {
+		if persist = c.onWrite(ent, mode); persist != nil {
+			wait, done = sh.WriteTurn(key)
+		}
+		return false, nil
+	}
+
+	/*
+		Check capacity of this shard.
+		A heavy value may need several entries evicted before it fits.
+	*/
+	for sh.Weight+weight > c.shardBudget {
+
+		// Evict one key using eviction policy
+		evicted := sh.Eviction.Evict()
+		if evicted == "" {
+			break
+		}
+
+		c.engine.Metrics.Eviction()
+		if victim, ok := sh.Store.Get(evicted); ok && evicted != key {
+			sh.Weight -= victim.Weight
+			removed = append(removed, removal{evicted, victim.Value, types.CauseEvicted})
+		}
+		sh.Store.Delete(evicted)
+		c.unschedule(evicted)
+	}
+
+	// Apply expiration logic; the write policy runs once the lock is released
+	if persist = c.onWrite(ent, mode); persist != nil {
+		wait, done = sh.WriteTurn(key)
 	}
 
-	// Apply write policy + expiration logic
-	c.engine.OnWrite(ctx, ent)
+	// A per-entry TTL is only computed when no explicit TTL was given
+	if ttl <= 0 {
+		c.expireAfterWrite(ent, old, now)
+	}
 
 	// Store entry in shard
 	sh.Store.Put(key, ent)
+	sh.Weight += weight
 
 	// Update eviction metadata
 	sh.Eviction.OnPut(key)
 
-	return nil
+	// Track the new deadline (or drop the old one)
+	c.schedule(ent)
+
+	return true, nil
 }
 
 /*
-Remove deletes a key from the cache immediately.
+onWrite applies the engine's expiration rules to a new entry.
+It returns the entry if the write policy must see it, nil otherwise: entries with an explicit TTL
+are temporary, cache-only values, and loaded or refreshed values are already in the backing store.
 */
+func (c *ShardedCache) onWrite(ent *types.CacheEntry, mode putMode) *types.CacheEntry {
+	c.engine.OnLoad(ent)
+	if mode != putWrite || ent.ExplicitTTL || c.engine.WritePolicy == nil {
+		return nil
+	}
+	return ent
+}
+
+// weigh returns the cost of an entry. Without a weigher every entry costs 1.
+func (c *ShardedCache) weigh(key string, value any) int64 {
+	if c.weigher == nil {
+		return 1
+	}
+	return c.weigher(key, value)
+}
+
+// Remove deletes a key from the cache immediately, and from the backing store through the write policy.
+// It does not give up on a slow backing store; use RemoveContext to bound it.
 func (c *ShardedCache) Remove(key string) {
+	c.RemoveContext(context.Background(), key)
+}
+
+/*
+RemoveContext is Remove, with ctx bounding how long the write policy may take:
+the Delete call under write-through, the wait for room in the queue under write-back (OverflowBlock).
+
+The backing store goes first: under write-through, a Get racing with Remove
+then either sees the old cached value or loads nothing, never reloads the deleted value.
+The delete takes its turn with the writes of the key, so it reaches the write policy in order with them.
+If ctx is done before its turn comes, only the cache is cleared.
+*/
+func (c *ShardedCache) RemoveContext(ctx context.Context, key string) {
+	if c.engine.WritePolicy != nil {
+		sh := c.selector.Select(key, c.shards)
+		sh.EvictMu.Lock()
+		wait, done := sh.WriteTurn(key)
+		sh.EvictMu.Unlock()
+
+		select {
+		case <-wait:
+			defer done()
+			c.engine.OnDelete(ctx, key)
+		case <-ctx.Done():
+			// Later writes of the key still wait for the ones ahead of this delete
+			go func() {
+				<-wait
+				done()
+			}()
+		}
+	}
+
+	if c.negative != nil {
+		c.negative.remove(key)
+	}
+	c.removeEntry(key, nil, types.CauseExplicit)
+}
+
+// isNegative reports whether key is remembered as missing from the backing store.
+func (c *ShardedCache) isNegative(key string) bool {
+	return c.negative != nil && c.negative.has(key, c.engine.Now())
+}
+
+/*
+removeEntry deletes a key and reports it to the removal listener.
+
+If expected is set, the key is only removed while it still maps to that exact entry.
+This stops a lazy expiration from deleting a fresh value written concurrently.
+It returns true if an entry was removed.
+*/
+func (c *ShardedCache) removeEntry(key string, expected *types.CacheEntry, cause types.RemovalCause) bool {
 	sh := c.selector.Select(key, c.shards)
 
 	sh.EvictMu.Lock()
-	defer sh.EvictMu.Unlock()
 
+	ent, ok := sh.Store.Get(key)
+	if expected != nil && ent != expected {
+		sh.EvictMu.Unlock()
+		return false
+	}
+
+	if ok {
+		sh.Weight -= ent.Weight
+	}
 	sh.Store.Delete(key)
+	sh.DrainReads()
 	sh.Eviction.Remove(key)
+	c.unschedule(key)
+
+	sh.EvictMu.Unlock()
+
+	// Notify outside the shard lock
+	if ok {
+		c.notify([]removal{{key, ent.Value, cause}})
+	}
+	return ok
+}
+
+// removal is one pending RemovalListener notification.
+type removal struct {
+	key   string
+	value any
+	cause types.RemovalCause
+}
+
+// notify delivers removal notifications. It must be called without holding a shard lock.
+func (c *ShardedCache) notify(removed []removal) {
+	if c.listener == nil {
+		return
+	}
+	for _, r := range removed {
+		c.listener(r.key, r.value, r.cause)
+	}
 }
 
 /*
@@ -202,7 +748,9 @@ func (c *ShardedCache) Expire(key string, ttl time.Duration) bool {
 		return false
 	}
 
-	ent.ExpireAt = time.Now().Add(ttl)
+	ent.SetExpireAt(c.engine.Now().Add(ttl))
+	ent.ExplicitTTL = true
+	c.schedule(ent)
 	return true
 }
 
@@ -213,22 +761,204 @@ func (c *ShardedCache) TTL(key string) time.Duration {
 	sh := c.selector.Select(key, c.shards)
 
 	ent, ok := sh.Store.Get(key)
-	if !ok || ent.ExpireAt.IsZero() {
+	if !ok || ent.ExpireAt().IsZero() {
 		return -1
 	}
 
-	d := time.Until(ent.ExpireAt)
+	d := ent.ExpireAt().Sub(c.engine.Now())
 	if d < 0 {
 		return -2
 	}
 	return d
 }
 
+/*
+isExpired checks whether an entry is expired.
+The engine's expiration strategy decides; deadlines computed by an Expiry
+are honored even when no strategy is configured.
+*/
+func (c *ShardedCache) isExpired(ent *types.CacheEntry) bool {
+	return c.expiredAt(ent, c.engine.Now())
+}
+
+// expiredAt checks whether an entry is expired at the given time.
+func (c *ShardedCache) expiredAt(ent *types.CacheEntry, now time.Time) bool {
+	if c.engine.Expiration != nil && c.engine.Expiration.IsExpired(ent, now) {
+		return true
+	}
+	return c.expiry != nil && !ent.ExpireAt().IsZero() && now.After(ent.ExpireAt())
+}
+
+// withinGrace reports whether an expired entry can still be served as stale,
+// that is, whether it was still live one grace window ago.
+func (c *ShardedCache) withinGrace(ent *types.CacheEntry) bool {
+	return c.staleGrace > 0 && !c.expiredAt(ent, c.engine.Now().Add(-c.staleGrace))
+}
+
+// isDead checks whether an entry is expired and past its stale grace window, so it can be removed.
+func (c *ShardedCache) isDead(ent *types.CacheEntry) bool {
+	return c.isExpired(ent) && !c.withinGrace(ent)
+}
+
+/*
+expiresEarly implements XFetch (probabilistic early expiration).
+
+Each reader of a TTL'd entry treats it as expired slightly early, with a probability that grows
+as ExpireAt gets closer and as the value gets more expensive to recompute:
+
+	now + LoadDuration * beta * -ln(rand) >= ExpireAt
+
+Usually one reader crosses the line first and reloads the value while everyone else
+still hits, so there is no cliff where every reader misses at the same moment.
+*/
+func (c *ShardedCache) expiresEarly(ent *types.CacheEntry) bool {
+	if c.earlyBeta <= 0 || ent.LoadDuration <= 0 || ent.ExpireAt().IsZero() {
+		return false
+	}
+
+	// 1-Float64 is in (0, 1], so the logarithm is finite
+	gap := time.Duration(float64(ent.LoadDuration) * c.earlyBeta * -math.Log(1-rand.Float64()))
+	return !c.engine.Now().Add(gap).Before(ent.ExpireAt())
+}
+
+// expireAfterWrite asks the Expiry for the TTL of a new or replacing entry.
+// old is the entry being replaced, or nil for a new key.
+func (c *ShardedCache) expireAfterWrite(ent, old *types.CacheEntry, now time.Time) {
+	if c.expiry == nil {
+		return
+	}
+
+	var d time.Duration
+	if old != nil {
+		d = c.expiry.ExpireAfterUpdate(ent.Key, ent.Value, now, remaining(old, now))
+	} else {
+		d = c.expiry.ExpireAfterCreate(ent.Key, ent.Value, now)
+	}
+	setTTL(ent, now, d)
+}
+
+/*
+expireAfterRead asks the Expiry for the TTL of an entry that was just read.
+An explicit TTL (PutWithTTL, or chosen by a GetOrLoad loader) is kept as is.
+It runs without the shard lock, like every read; the deadline is stored atomically.
+*/
+func (c *ShardedCache) expireAfterRead(ent *types.CacheEntry) {
+	if c.expiry == nil || ent.ExplicitTTL {
+		return
+	}
+
+	now := c.engine.Now()
+	prev := ent.ExpireAt()
+	setTTL(ent, now, c.expiry.ExpireAfterRead(ent.Key, ent.Value, now, remaining(ent, now)))
+
+	// Keep the timing wheel in step with the new deadline
+	if !ent.ExpireAt().Equal(prev) {
+		c.schedule(ent)
+	}
+}
+
+// remaining returns how long an entry has left to live, or 0 if it has no TTL.
+func remaining(ent *types.CacheEntry, now time.Time) time.Duration {
+	if ent.ExpireAt().IsZero() {
+		return 0
+	}
+	return max(ent.ExpireAt().Sub(now), 0)
+}
+
+// setTTL applies a computed TTL. A non-positive TTL means the entry does not expire.
+func setTTL(ent *types.CacheEntry, now time.Time, d time.Duration) {
+	if d > 0 {
+		ent.SetExpireAt(now.Add(d))
+	} else {
+		ent.SetExpireAt(time.Time{})
+	}
+}
+
+// schedule puts an entry's deadline on the timing wheel, or removes it if the entry has no TTL.
+func (c *ShardedCache) schedule(ent *types.CacheEntry) {
+	if c.wheel == nil {
+		return
+	}
+	if ent.ExpireAt().IsZero() {
+		c.wheel.Cancel(ent.Key)
+		return
+	}
+	c.wheel.Schedule(ent.Key, ent.ExpireAt())
+}
+
+// unschedule drops a key's deadline from the timing wheel.
+func (c *ShardedCache) unschedule(key string) {
+	if c.wheel != nil {
+		c.wheel.Cancel(key)
+	}
+}
+
+/*
+onDeadline is called by the timing wheel when a key's deadline passes.
+
+The deadline may have moved since it was scheduled (sliding TTL on reads),
+so the entry is checked again:
+- Expired → remove it
+- Expired but kept as stale → check again when the grace window ends
+- Deadline pushed into the future → schedule it again
+*/
+func (c *ShardedCache) onDeadline(key string) {
+	sh := c.selector.Select(key, c.shards)
+
+	ent, ok := sh.Store.Get(key)
+	if !ok {
+		return
+	}
+
+	if c.isDead(ent) {
+		if c.removeEntry(key, ent, types.CauseExpired) {
+			c.engine.Metrics.Expire()
+		}
+		return
+	}
+
+	now := c.engine.Now()
+	if c.isExpired(ent) {
+		c.wheel.Schedule(key, now.Add(c.staleGrace))
+		return
+	}
+
+	if ent.ExpireAt().After(now) {
+		c.wheel.Schedule(key, ent.ExpireAt())
+	}
+}
+
+// refreshed swaps a value reloaded by a refresh hook into the cache.
+// Keys that were removed while the reload was running are not brought back, reloads of keys
+// with undelivered writes are dropped, and an explicit TTL keeps its deadline unless the reload returned a new one.
+// It reports whether the value was stored.
+func (c *ShardedCache) refreshed(ctx context.Context, key string, value any, ttl time.Duration) bool {
+	// The backing store is behind on this key, so the reload is older than the cached value
+	if _, _, pending := c.engine.Pending(key); pending {
+		return false
+	}
+	stored, _ := c.put(ctx, key, loaded{value: value, ttl: ttl}, putRefresh)
+	return stored
+}
+
 /*
 Close gracefully shuts down the cache.
 This is important for write-back policies,so pending writes are flushed.
 */
 func (c *ShardedCache) Close() {
+	// Stop background expiration before the write policy goes away
+	if c.sweeper != nil {
+		c.sweeper.close()
+	}
+	if c.wheel != nil {
+		c.wheel.Close()
+	}
+
+	// Let running refreshes finish, so they do not write into a closed write policy
+	if r, ok := c.engine.Refresh.(interface{ Close() }); ok {
+		r.Close()
+	}
+
 	if c.engine.WritePolicy != nil {
 		c.engine.WritePolicy.Close()
 	}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/krisalay/in-memory-cache/shard"
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+/*
+This file implements active expiration.
+
+Without it, expired entries are only removed when someone calls Get on them.
+Keys that are never read again would stay in memory forever and take capacity from live keys.
+
+The sweeper works like Redis's active expire cycle:
+- Every interval, visit the shards one by one
+- Sample a few entries that have a TTL
+- Delete the expired ones
+- If many of the sample were expired, the shard probably has more: sample it again
+- Stop as soon as the cycle has used up its time budget
+*/
+
+const (
+	// sweepSampleSize is how many TTL'd entries are checked per sample.
+	sweepSampleSize = 20
+
+	// sweepScanLimit caps how many entries are looked at to find one sample,
+	// so shards full of keys without TTL stay cheap.
+	sweepScanLimit = 4 * sweepSampleSize
+
+	// sweepRepeatPercent: if more than this share of a sample was expired, sample the shard again.
+	sweepRepeatPercent = 25
+)
+
+// sweeper is the background goroutine that actively removes expired entries.
+type sweeper struct {
+	// interval is how often a sweep cycle runs.
+	interval time.Duration
+
+	// budget is the maximum time a single cycle may take.
+	budget time.Duration
+
+	// next is the shard the next cycle starts from, so every shard gets its turn.
+	next int
+
+	// stop tells the goroutine to exit; wg waits for it.
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// start launches the sweeper goroutine for the given cache.
+func (s *sweeper) start(c *ShardedCache) {
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.run(c)
+}
+
+// close stops the sweeper and waits for the running cycle to finish.
+func (s *sweeper) close() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *sweeper) run(c *ShardedCache) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.cycle(c)
+		}
+	}
+}
+
+// cycle runs one sweep over the shards, within the time budget.
+// If the budget runs out, the next cycle resumes at the shard that was interrupted.
+// The budget limits real CPU time, so it is measured on the wall clock, not the engine's Clock.
+func (s *sweeper) cycle(c *ShardedCache) {
+	deadline := time.Now().Add(s.budget)
+
+	for i := 0; i < len(c.shards); i++ {
+		idx := (s.next + i) % len(c.shards)
+
+		for {
+			sampled, expired := s.sample(c, c.shards[idx])
+
+			if time.Now().After(deadline) {
+				s.next = idx
+				return
+			}
+
+			// Mostly live keys: move on to the next shard
+			if sampled == 0 || expired*100 <= sampled*sweepRepeatPercent {
+				break
+			}
+		}
+	}
+}
+
+// sample checks up to sweepSampleSize TTL'd entries of one shard and removes the expired ones.
+// Go map iteration starts at a random position, so every call sees a different sample.
+func (s *sweeper) sample(c *ShardedCache, sh *shard.Shard) (sampled, expired int) {
+	var dead []*types.CacheEntry
+
+	scanned := 0
+	sh.Store.Range(func(key string, ent *types.CacheEntry) bool {
+		scanned++
+		if !ent.ExpireAt().IsZero() {
+			sampled++
+			if c.isDead(ent) {
+				dead = append(dead, ent)
+			}
+		}
+		return sampled < sweepSampleSize && scanned < sweepScanLimit
+	})
+
+	// Delete outside Range: the store must not be modified while iterating
+	for _, ent := range dead {
+		if c.removeEntry(ent.Key, ent, types.CauseExpired) {
+			c.engine.Metrics.Expire()
+			expired++
+		}
+	}
+	return sampled, expired
+}
@@ -0,0 +1,31 @@
+package types
+
+// This file defines the states a circuit breaker reports through Metrics.
+
+// BreakerState is the state of a circuit breaker guarding the backing store.
+type BreakerState int
+
+const (
+	// BreakerClosed: calls go through to the backing store as usual.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen: the backing store kept failing, so calls fail fast without reaching it.
+	BreakerOpen
+
+	// BreakerHalfOpen: the cooldown is over and a single trial call is let through.
+	BreakerHalfOpen
+)
+
+// String returns a readable name for the state, useful for logs and dashboards.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+/*
+Clock is where the cache gets the current time from.
+
+Every TTL decision (expiration, refresh, deadlines) asks the Clock instead of calling
+time.Now directly. In production this is the system clock; in tests it can be a fake
+clock that is moved forward by hand, so TTL behavior is tested without sleeping.
+*/
+type Clock interface {
+
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// SystemClock is the default Clock. It simply returns the wall-clock time.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
@@ -1,13 +1,65 @@
 package types
 
-import "time"
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
 
-// CacheEntry is intentionally mutable for timestamps.
-// Timestamp races are acceptable.
+/*
+CacheEntry is one cached value and its metadata.
+
+Reads do not take the shard lock, yet some expiration strategies move the deadline
+on every read (sliding TTL). The access time and the deadline are therefore stored
+atomically, behind the methods below. The other fields do not change once the entry is stored.
+*/
 type CacheEntry struct {
-	Key            string
-	Value          any
-	CreatedAt      time.Time
-	LastAccessedAt time.Time
-	ExpireAt       time.Time // zero => no TTL
+	Key          string
+	Value        any
+	CreatedAt    time.Time
+	ExplicitTTL  bool          // ExpireAt was set by the caller or the loader, not by the expiration strategy
+	Weight       int64         // cost counted against capacity
+	LoadDuration time.Duration // time it took to load the value; zero if it was Put directly
+
+	// lastAccessedAt and expireAt are Unix nanoseconds; zero is the zero time.
+	lastAccessedAt atomic.Int64
+	expireAt       atomic.Int64
+
+	// Reload is the per-call loader the value came from (GetOrLoad), used by refresh hooks.
+	// Nil means the cache's Loader.
+	Reload func(ctx context.Context) (any, time.Duration, error)
+}
+
+// LastAccessedAt returns when the entry was last read or written.
+func (e *CacheEntry) LastAccessedAt() time.Time {
+	return fromNanos(e.lastAccessedAt.Load())
+}
+
+// SetLastAccessedAt records an access to the entry.
+func (e *CacheEntry) SetLastAccessedAt(t time.Time) {
+	e.lastAccessedAt.Store(toNanos(t))
+}
+
+// ExpireAt returns when the entry expires. The zero time means it has no TTL.
+func (e *CacheEntry) ExpireAt() time.Time {
+	return fromNanos(e.expireAt.Load())
+}
+
+// SetExpireAt sets when the entry expires. The zero time removes its TTL.
+func (e *CacheEntry) SetExpireAt(t time.Time) {
+	e.expireAt.Store(toNanos(t))
+}
+
+func toNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
 }
@@ -1,6 +1,10 @@
 package types
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"sort"
+)
 
 // Loader is the contract between the cache and the backing store.
 type Loader interface {
@@ -27,3 +31,65 @@ type Loader interface {
 	*/
 	Put(ctx context.Context, key string, value any) error
 }
+
+/*
+BatchLoader is an optional capability of a Loader.
+
+If the Loader implements it, GetAll fetches all of its missing keys
+with a single LoadAll call (one round trip) instead of one Load per key.
+*/
+type BatchLoader interface {
+
+	/*
+		LoadAll fetches several keys at once.
+
+		Keys that do not exist in the backing store are simply left out of the result.
+		If only some keys failed, return the others along with a KeyErrors:
+		the cache then fails only the callers of the failed keys.
+	*/
+	LoadAll(ctx context.Context, keys []string) (map[string]any, error)
+}
+
+/*
+KeyErrors is the error of a batch load in which only some keys failed.
+It maps each failed key to its own error. Any other error fails every key of the batch.
+*/
+type KeyErrors map[string]error
+
+func (e KeyErrors) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(keys) == 1 {
+		return fmt.Sprintf("load %q: %v", keys[0], e[keys[0]])
+	}
+	return fmt.Sprintf("%d keys failed to load, first %q: %v", len(keys), keys[0], e[keys[0]])
+}
+
+/*
+BatchPutter is an optional capability of a Loader.
+
+If the Loader implements it, write-back flushes a whole batch of pending writes
+with a single PutAll call (one round trip) instead of one Put per key.
+*/
+type BatchPutter interface {
+
+	// PutAll writes several key-value pairs to the backing store at once.
+	PutAll(ctx context.Context, values map[string]any) error
+}
+
+/*
+Deleter is an optional capability of a Loader.
+
+If the Loader implements it, Remove deletes the key from the backing store too,
+through the write policy: right away under write-through, as a queued tombstone under write-back.
+Without it, Remove only affects the cache.
+*/
+type Deleter interface {
+
+	// Delete removes a key from the backing store. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -20,10 +20,55 @@ type Metrics interface {
 	// Expire is called when a key is removed because it has passed its TTL (time-based expiration).
 	Expire()
 
-	// Refresh is called when a refresh hook is triggered.
+	// Refresh is called when a refresh hook reloads an entry and swaps the new value in.
 	Refresh()
 }
 
+/*
+The interfaces below are optional extensions of Metrics, for features that not every cache uses.
+Adding them to Metrics would break every existing implementation. Instead, a component
+checks whether the configured Metrics implements the extension it reports to.
+*/
+
+// StaleMetrics is implemented by Metrics that want to know about stale-if-error serves.
+type StaleMetrics interface {
+
+	// Stale is called when an expired value is served because the backing store failed to reload it.
+	Stale()
+}
+
+// BreakerMetrics is implemented by Metrics that want to know about circuit breaker state changes.
+type BreakerMetrics interface {
+
+	// BreakerStateChanged is called when the circuit breaker with the given name moves to a new state.
+	BreakerStateChanged(name string, state BreakerState)
+}
+
+// WriteMetrics is implemented by Metrics that want to know how writes to the backing store fare.
+type WriteMetrics interface {
+
+	// WriteRetried is called every time a write policy retries a failed write to the backing store.
+	WriteRetried()
+
+	// WriteFailed is called when a write to the backing store failed for good, after all retries.
+	WriteFailed()
+
+	// WriteDeadLettered is called when a failed write was handed to the dead-letter sink.
+	WriteDeadLettered()
+
+	// WriteDropped is called when write-back drops a write because its queue is full.
+	WriteDropped()
+
+	// WriteBlocked is called when a writer has to wait for room in the write-back queue.
+	WriteBlocked()
+
+	// WriteSpilled is called when a write overflows the write-back queue and is spilled to disk.
+	WriteSpilled()
+
+	// WriteQueueDepth reports the number of writes waiting in the write-back queue (a gauge), whenever it changes.
+	WriteQueueDepth(depth int)
+}
+
 /*
 NoopMetrics is a "do nothing" implementation of Metrics.
 
@@ -43,10 +88,20 @@ that simply ignores all metric events.
 type NoopMetrics struct{}
 
 // All methods below intentionally do nothing.
-// This satisfies the Metrics interface without side effects.
+// This satisfies the Metrics interface, and its optional extensions, without side effects.
 
 func (NoopMetrics) Hit()      {}
 func (NoopMetrics) Miss()     {}
 func (NoopMetrics) Eviction() {}
 func (NoopMetrics) Expire()   {}
 func (NoopMetrics) Refresh()  {}
+func (NoopMetrics) Stale()    {}
+
+func (NoopMetrics) BreakerStateChanged(string, BreakerState) {}
+func (NoopMetrics) WriteRetried()                            {}
+func (NoopMetrics) WriteFailed()                             {}
+func (NoopMetrics) WriteDeadLettered()                       {}
+func (NoopMetrics) WriteDropped()                            {}
+func (NoopMetrics) WriteBlocked()                            {}
+func (NoopMetrics) WriteSpilled()                            {}
+func (NoopMetrics) WriteQueueDepth(int)                      {}
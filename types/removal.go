@@ -0,0 +1,49 @@
+package types
+
+// This file defines how the cache reports entries it drops.
+
+// RemovalCause tells a RemovalListener WHY an entry left the cache.
+type RemovalCause int
+
+const (
+	// CauseEvicted: the entry was removed because its shard ran out of space.
+	CauseEvicted RemovalCause = iota
+
+	// CauseExpired: the entry was removed because it passed its TTL.
+	CauseExpired
+
+	// CauseExplicit: the entry was removed by a call to Remove.
+	CauseExplicit
+
+	// CauseReplaced: the entry was overwritten by a new value for the same key.
+	CauseReplaced
+)
+
+// String returns a readable name for the cause, useful for logs and audits.
+func (c RemovalCause) String() string {
+	switch c {
+	case CauseEvicted:
+		return "evicted"
+	case CauseExpired:
+		return "expired"
+	case CauseExplicit:
+		return "explicit"
+	case CauseReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+/*
+RemovalListener is called every time an entry leaves the cache.
+
+It receives the key, the value that was dropped and the cause. Typical uses:
+- Release resources held by cached values (files, connections, buffers)
+- Audit invalidations
+
+The cache calls it AFTER releasing the shard lock, so the listener may safely
+call back into the cache. It still runs on the goroutine that caused the removal,
+so slow listeners slow down that operation.
+*/
+type RemovalListener func(key string, value any, cause RemovalCause)
@@ -0,0 +1,13 @@
+package types
+
+/*
+Weigher computes the cost of a single cache entry.
+
+By default every entry costs 1, so capacity is simply "number of entries".
+With a Weigher, capacity becomes a budget (bytes, rows, anything) and a
+5 MB blob can be made to cost far more than a 10-byte string.
+
+The returned weight must be non-negative and must not change while the
+entry is cached. The function is called on every write, so keep it cheap.
+*/
+type Weigher func(key string, value any) int64
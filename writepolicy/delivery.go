@@ -0,0 +1,66 @@
+package writepolicy
+
+import (
+	"context"
+	"time"
+)
+
+// This file holds the error handling shared by the write policies: retries, the error callback and dead letters.
+
+/*
+DeadLetterSink receives writes that could not be persisted, even after retries.
+It could append them to a file, publish them to a queue, or page someone.
+
+A failed delete is reported with a nil value.
+*/
+type DeadLetterSink interface {
+
+	// DeadLetter stores a failed write along with the error of its last attempt.
+	DeadLetter(ctx context.Context, key string, value any, err error) error
+}
+
+// DeadLetterFunc adapts a plain function to a DeadLetterSink.
+type DeadLetterFunc func(ctx context.Context, key string, value any, err error) error
+
+func (f DeadLetterFunc) DeadLetter(ctx context.Context, key string, value any, err error) error {
+	return f(ctx, key, value, err)
+}
+
+/*
+deliver runs put until it succeeds or the configured attempts run out.
+n is the number of writes put carries (a batch counts each of its writes), for metrics.
+It stops waiting early when ctx is done.
+*/
+func (c *config) deliver(ctx context.Context, n int, put func() error) error {
+	err := put()
+	for attempt := 1; err != nil && attempt < c.attempts; attempt++ {
+		timer := time.NewTimer(c.backoff.Delay(attempt - 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		for range n {
+			c.metrics.WriteRetried()
+		}
+		err = put()
+	}
+	return err
+}
+
+/*
+fail handles a write that failed for good: it is counted, reported to the
+error callback and handed to the dead-letter sink.
+*/
+func (c *config) fail(ctx context.Context, key string, value any, err error) {
+	c.metrics.WriteFailed()
+	if c.onError != nil {
+		c.onError(key, value, err)
+	}
+
+	if c.deadLetter != nil && c.deadLetter.DeadLetter(ctx, key, value, err) == nil {
+		c.metrics.WriteDeadLettered()
+	}
+}
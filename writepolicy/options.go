@@ -0,0 +1,128 @@
+package writepolicy
+
+import (
+	"time"
+
+	"github.com/krisalay/in-memory-cache/loader"
+	"github.com/krisalay/in-memory-cache/types"
+)
+
+// defaultBatchSize is how many pending writes are sent to the backing store in one flush, by default.
+const defaultBatchSize = 100
+
+/*
+Option configures optional write policy behavior.
+Options are passed to the policy constructors after the required arguments,
+so existing callers keep working unchanged.
+*/
+type Option func(*config)
+
+// config holds the optional settings shared by the write policies.
+type config struct {
+	flushInterval time.Duration
+	batchSize     int
+
+	overflow OverflowMode
+	spillDir string
+
+	attempts   int
+	backoff    loader.Backoff
+	deadLetter DeadLetterSink
+	onError    func(key string, value any, err error)
+	metrics    types.WriteMetrics
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{batchSize: defaultBatchSize, attempts: 1, metrics: types.NoopMetrics{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+/*
+WithFlushInterval makes write-back wait and flush pending writes every interval,
+instead of as soon as the worker is free. Waiting longer coalesces more writes
+to the same key into one, at the cost of the backing store lagging further behind.
+A batch that fills up is flushed right away, without waiting for the interval.
+*/
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.flushInterval = interval
+	}
+}
+
+// WithBatchSize sets how many pending writes write-back sends to the backing store in one batch.
+// Defaults to 100.
+func WithBatchSize(n int) Option {
+	return func(c *config) {
+		c.batchSize = max(n, 1)
+	}
+}
+
+/*
+WithRetry retries failed writes to the backing store, up to attempts tries in total,
+waiting between them as backoff says. Write-through retries on the caller's goroutine,
+within the caller's ctx; write-back retries on its worker.
+*/
+func WithRetry(attempts int, backoff loader.Backoff) Option {
+	return func(c *config) {
+		c.attempts = max(attempts, 1)
+		c.backoff = backoff
+	}
+}
+
+// WithDeadLetter hands writes that failed on every retry to sink, instead of dropping them.
+func WithDeadLetter(sink DeadLetterSink) Option {
+	return func(c *config) {
+		c.deadLetter = sink
+	}
+}
+
+// WithOnError registers a callback that is told about every write that failed for good,
+// after all retries and before it goes to the dead-letter sink.
+func WithOnError(fn func(key string, value any, err error)) Option {
+	return func(c *config) {
+		c.onError = fn
+	}
+}
+
+// WithMetrics reports retried, failed, dropped and queued writes, if m implements types.WriteMetrics.
+func WithMetrics(m types.Metrics) Option {
+	return func(c *config) {
+		if wm, ok := m.(types.WriteMetrics); ok {
+			c.metrics = wm
+		}
+	}
+}
+
+// OverflowMode decides what write-back does with a write when its queue is full.
+type OverflowMode int
+
+const (
+	// OverflowDropNewest drops the incoming write. The cache stays fast; the backing store misses it.
+	OverflowDropNewest OverflowMode = iota
+
+	// OverflowDropOldest drops the oldest queued write to make room for the incoming one.
+	OverflowDropOldest
+
+	// OverflowBlock makes the writer wait for room, until its ctx is done; then the write is dropped.
+	OverflowBlock
+
+	// OverflowSpill writes overflowing writes to a file on disk and feeds them back in order as the queue drains.
+	OverflowSpill
+)
+
+// WithOverflow selects what write-back does when its queue is full. Defaults to OverflowDropNewest.
+func WithOverflow(mode OverflowMode) Option {
+	return func(c *config) {
+		c.overflow = mode
+	}
+}
+
+// WithSpillDir sets where OverflowSpill keeps its spill file. Defaults to a new temporary directory.
+func WithSpillDir(dir string) Option {
+	return func(c *config) {
+		c.spillDir = dir
+	}
+}
@@ -0,0 +1,394 @@
+package writepolicy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+This file implements the on-disk log behind durable write-back.
+
+Every queued write is appended to the log BEFORE it is acknowledged to the cache.
+Every record carries a sequence number. Once a write is done with (delivered, superseded
+or failed for good), its sequence number is appended to the log in an ack record.
+On startup, the writes that were not acknowledged are replayed, oldest first.
+
+The log is split into segment files. Segments are deleted oldest first, once every write
+in them is acknowledged: an ack record is always in the same segment as its write or a
+later one, so it outlives the write it acknowledges.
+
+Record layout (little endian):
+
+	[4 bytes payload length][4 bytes CRC-32 of payload][payload: gob-encoded record]
+
+A torn write at the end of a segment (short or corrupt record) ends the replay of that segment.
+
+Values are encoded with encoding/gob inside an interface, so custom value types
+must be registered with gob.Register before they are written.
+*/
+
+// SyncPolicy decides how often the durable write-back log is fsynced to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every write. Nothing acknowledged is lost, even on power loss; slowest.
+	SyncAlways SyncPolicy = iota
+
+	// SyncEverySecond fsyncs once per second. A power loss may lose the last second of writes.
+	SyncEverySecond
+
+	// SyncNever leaves flushing to the operating system. Survives process crashes, not power loss.
+	SyncNever
+)
+
+const (
+	// segmentMaxBytes is the size at which the active segment is closed and a new one is started.
+	segmentMaxBytes = 4 << 20
+
+	// segmentExt is the file extension of segment files.
+	segmentExt = ".wal"
+
+	// recordHeaderSize is the size of the length + checksum header of a record.
+	recordHeaderSize = 8
+)
+
+// errCorruptRecord is returned for a record whose checksum does not match.
+var errCorruptRecord = errors.New("writepolicy: corrupt log record")
+
+/*
+ErrNotDurable is reported (wrapped, with the cause) for a write that durable write-back could not log,
+for example a value whose type was not registered with gob.Register, or a full disk.
+Such a write is failed right away, like a write the backing store rejected.
+*/
+var ErrNotDurable = errors.New("writepolicy: write could not be logged")
+
+// record is one write (or delete) stored in the log, or an ack record.
+type record struct {
+	// Seq numbers the writes of the log in order, starting at 1.
+	Seq uint64
+
+	Key   string
+	Value any
+
+	// Delete marks a tombstone: the key must be deleted from the backing store.
+	Delete bool
+
+	// Acks lists the sequence numbers of acknowledged writes. Set only on ack records.
+	Acks []uint64
+}
+
+// segment is one file of the log.
+type segment struct {
+	id   uint64
+	path string
+
+	// live is the number of records not yet acknowledged by the backing store.
+	live int
+}
+
+// replayed is a record read back from the log on startup.
+type replayed struct {
+	rec record
+	seg *segment
+}
+
+/*
+segmentLog is an append-only log of pending writes.
+It is not safe for concurrent use; WriteBackPolicy guards it with its mutex.
+*/
+type segmentLog struct {
+	dir  string
+	sync SyncPolicy
+
+	// segments are ordered oldest first; the last one is being appended to.
+	segments []*segment
+
+	active     *os.File
+	activeSize int64
+
+	// dirty is set when appended data has not been fsynced yet.
+	dirty bool
+
+	// seq is the sequence number of the last write appended.
+	seq uint64
+
+	// acks holds the acknowledged writes not yet recorded in the log (see checkpoint).
+	acks []uint64
+}
+
+/*
+openSegmentLog opens the log in dir, creating dir if needed.
+It returns the writes a previous run did not acknowledge, oldest first.
+*/
+func openSegmentLog(dir string, sync SyncPolicy) (*segmentLog, []replayed, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	ids, err := segmentIDs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := &segmentLog{dir: dir, sync: sync}
+
+	var writes []replayed
+	acked := make(map[uint64]bool)
+	for _, id := range ids {
+		seg := &segment{id: id, path: l.path(id)}
+		recs, err := readSegment(seg.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, rec := range recs {
+			for _, seq := range rec.Acks {
+				acked[seq] = true
+			}
+			if rec.Acks == nil {
+				writes = append(writes, replayed{rec, seg})
+			}
+			l.seq = max(l.seq, rec.Seq)
+		}
+		l.segments = append(l.segments, seg)
+	}
+
+	// Writes already delivered, superseded or failed must not be sent again
+	var pending []replayed
+	for _, r := range writes {
+		if !acked[r.rec.Seq] {
+			pending = append(pending, r)
+			r.seg.live++
+		}
+	}
+
+	// Old segments are never appended to, since their tail may be torn.
+	// New writes go to a fresh segment.
+	next := uint64(1)
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+	if err := l.rotate(next); err != nil {
+		return nil, nil, err
+	}
+
+	// Segments with nothing left to replay can go right away
+	l.trim()
+	return l, pending, nil
+}
+
+// segmentIDs lists the ids of the segment files in dir, in ascending order.
+func segmentIDs(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// readSegment decodes the records of one segment, stopping at the first torn record.
+func readSegment(path string) ([]record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var recs []record
+	for {
+		rec, _, err := readRecord(r)
+		if err != nil {
+			return recs, nil
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// encodeRecord returns the on-disk form of a record: header followed by payload.
+func encodeRecord(rec record) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(&rec); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(buf, payload.Bytes()...), nil
+}
+
+// readRecord reads the next record and returns it with its size on disk.
+// A short or corrupt record is an error.
+func readRecord(r io.Reader) (record, int, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return record{}, 0, err
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	sum := binary.LittleEndian.Uint32(header[4:8])
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return record{}, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return record{}, 0, errCorruptRecord
+	}
+
+	var rec record
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+		return record{}, 0, err
+	}
+	return rec, recordHeaderSize + int(size), nil
+}
+
+func (l *segmentLog) path(id uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// append writes a record and returns the segment holding it, and its sequence number.
+func (l *segmentLog) append(rec record) (*segment, uint64, error) {
+	rec.Seq = l.seq + 1
+	if err := l.write(rec); err != nil {
+		return nil, 0, err
+	}
+	l.seq = rec.Seq
+
+	seg := l.segments[len(l.segments)-1]
+	seg.live++
+	return seg, rec.Seq, nil
+}
+
+// write appends one record to the active segment, starting a new segment when it is full.
+func (l *segmentLog) write(rec record) error {
+	buf, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	if l.activeSize >= segmentMaxBytes {
+		if err := l.rotate(l.segments[len(l.segments)-1].id + 1); err != nil {
+			return err
+		}
+	}
+
+	// A failed append is cut off again, so it neither tears the segment nor gets replayed
+	prev := l.activeSize
+	if _, err := l.active.Write(buf); err != nil {
+		_ = l.active.Truncate(prev)
+		return err
+	}
+	l.activeSize += int64(len(buf))
+	l.dirty = true
+
+	if l.sync == SyncAlways {
+		if err := l.flushToDisk(); err != nil {
+			_ = l.active.Truncate(prev)
+			l.activeSize = prev
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate closes the active segment and starts a new one with the given id.
+func (l *segmentLog) rotate(id uint64) error {
+	if l.active != nil {
+		if err := l.flushToDisk(); err != nil {
+			return err
+		}
+		if err := l.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	seg := &segment{id: id, path: l.path(id)}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.active = f
+	l.activeSize = 0
+	l.segments = append(l.segments, seg)
+	return nil
+}
+
+// ack marks the write seq of seg as done with. It is recorded in the log by the next checkpoint.
+func (l *segmentLog) ack(seg *segment, seq uint64) {
+	seg.live--
+	l.acks = append(l.acks, seq)
+}
+
+/*
+checkpoint records the acknowledged writes in the log, then deletes the segments
+that are fully acknowledged. If the ack record cannot be written, the acks are kept
+for the next checkpoint; until then, a crash only means those writes are sent again.
+*/
+func (l *segmentLog) checkpoint() {
+	if len(l.acks) == 0 {
+		return
+	}
+	if err := l.write(record{Acks: l.acks}); err != nil {
+		return
+	}
+	l.acks = nil
+	l.trim()
+}
+
+// trim deletes fully acknowledged segments, oldest first, stopping at the first one still needed.
+// The active segment is kept.
+func (l *segmentLog) trim() {
+	for len(l.segments) > 1 && l.segments[0].live == 0 {
+		l.release(l.segments[0])
+	}
+}
+
+// release deletes the oldest segment.
+func (l *segmentLog) release(seg *segment) {
+	l.segments = l.segments[1:]
+	_ = os.Remove(seg.path)
+}
+
+// flushToDisk fsyncs the active segment if it has unsynced data.
+func (l *segmentLog) flushToDisk() error {
+	if !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.active.Sync()
+}
+
+// close records the last acks, syncs and closes the log. Fully acknowledged segments are deleted.
+func (l *segmentLog) close() error {
+	l.checkpoint()
+	err := errors.Join(l.flushToDisk(), l.active.Close())
+	for len(l.segments) > 0 && l.segments[0].live == 0 {
+		l.release(l.segments[0])
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package writepolicy
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+/*
+spillQueue is a FIFO of writes kept in a file, used by OverflowSpill
+when the in-memory write-back queue is full.
+
+Writes are appended at the end of the file and read back from the front.
+Once every spilled write has been read back, the file is truncated and reused.
+It uses the same record format as the durable log. It is not safe for
+concurrent use; WriteBackPolicy guards it with its mutex.
+*/
+type spillQueue struct {
+	f *os.File
+
+	// tmpDir is the directory created for the file, removed on close; empty if the caller chose one.
+	tmpDir string
+
+	readOff  int64
+	writeOff int64
+
+	// segs holds, in durable mode, the log segment of each spilled write, in order.
+	segs []*segment
+
+	// last maps each spilled key to the offset of its newest write, for latest.
+	last map[string]int64
+}
+
+// openSpillQueue creates the spill file in dir, or in a new temporary directory if dir is empty.
+func openSpillQueue(dir string) (*spillQueue, error) {
+	var tmpDir string
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "writeback-spill-")
+		if err != nil {
+			return nil, err
+		}
+		dir, tmpDir = tmp, tmp
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(dir, "spill-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillQueue{f: f, tmpDir: tmpDir, last: make(map[string]int64)}, nil
+}
+
+// len returns the number of spilled writes not yet read back.
+func (q *spillQueue) len() int {
+	return len(q.segs)
+}
+
+// push appends a write to the end of the queue.
+func (q *spillQueue) push(req writeReq) error {
+	buf, err := encodeRecord(record{Seq: req.seq, Key: req.key, Value: req.value, Delete: req.delete})
+	if err != nil {
+		return err
+	}
+	if _, err := q.f.WriteAt(buf, q.writeOff); err != nil {
+		return err
+	}
+	q.last[req.key] = q.writeOff
+	q.writeOff += int64(len(buf))
+	q.segs = append(q.segs, req.seg)
+	return nil
+}
+
+/*
+pop reads the oldest write back. The file is reset once the queue is empty.
+If the file cannot be read, every spilled write is dropped, so the queue does not get stuck.
+*/
+func (q *spillQueue) pop() (writeReq, error) {
+	rec, size, err := readRecord(io.NewSectionReader(q.f, q.readOff, q.writeOff-q.readOff))
+	if err != nil {
+		q.reset()
+		return writeReq{}, err
+	}
+	if q.last[rec.Key] == q.readOff {
+		delete(q.last, rec.Key)
+	}
+	q.readOff += int64(size)
+
+	req := writeReq{ctx: context.Background(), key: rec.Key, value: rec.Value, delete: rec.Delete, seg: q.segs[0], seq: rec.Seq}
+	q.segs = q.segs[1:]
+
+	if len(q.segs) == 0 {
+		q.reset()
+	}
+	return req, nil
+}
+
+// latest reads back the newest spilled write of key, without removing it.
+func (q *spillQueue) latest(key string) (writeReq, bool) {
+	off, ok := q.last[key]
+	if !ok {
+		return writeReq{}, false
+	}
+	rec, _, err := readRecord(io.NewSectionReader(q.f, off, q.writeOff-off))
+	if err != nil {
+		return writeReq{}, false
+	}
+	return writeReq{key: rec.Key, value: rec.Value, delete: rec.Delete}, true
+}
+
+// reset empties the queue and truncates the file.
+func (q *spillQueue) reset() {
+	q.readOff, q.writeOff = 0, 0
+	q.segs = nil
+	clear(q.last)
+	_ = q.f.Truncate(0)
+}
+
+// close deletes the spill file. Writes still in it are lost.
+func (q *spillQueue) close() {
+	name := q.f.Name()
+	_ = q.f.Close()
+	_ = os.Remove(name)
+	if q.tmpDir != "" {
+		_ = os.Remove(q.tmpDir)
+	}
+}
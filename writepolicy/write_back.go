@@ -2,46 +2,99 @@ package writepolicy
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/krisalay/in-memory-cache/types"
 )
 
 // This file implements the "write-back" policy.
 
-// writeReq represents one pending write operationthat needs to be sent to the backing store.
+// writeReq represents one pending write operation that needs to be sent to the backing store.
 type writeReq struct {
 	ctx   context.Context
 	key   string
 	value any
+
+	// delete marks a tombstone: the key is deleted from the backing store instead of written.
+	delete bool
+
+	// seg is the log segment holding this write, in durable mode, and seq its sequence number in the log.
+	seg *segment
+	seq uint64
 }
 
 /*
 WriteBackPolicy manages asynchronous writes to the backing store.
+
+Pending writes are COALESCED: only the latest value of each key is kept (last write wins).
+A hot key written 10k times between two flushes costs one write to the backing store, not 10k.
+
+Pending writes are flushed in batches:
+- As soon as the worker is free (default), or every flush interval (WithFlushInterval)
+- Right away when a full batch is waiting (WithBatchSize)
+
+If the backing store implements types.BatchPutter, each batch is one PutAll call.
+
+If it implements types.Deleter, removed keys are queued as tombstones. A tombstone
+coalesces with the writes of its key like any other write, so whichever came last wins.
+
+By default the queue lives only in memory, so a crash loses it.
+NewDurableWriteBackPolicy adds an on-disk log for at-least-once delivery.
 */
 type WriteBackPolicy struct {
 
 	// store is the backing store (DB, API, etc.)
 	store types.Loader
 
-	// ch is a buffered channel that holds pending write requests.
+	// buffer is the maximum number of distinct keys waiting to be flushed.
 	//
 	// Buffering is important:
 	// - Allows bursts of writes without blocking
 	// - Improves throughput
-	ch chan writeReq
+	buffer int
+
+	cfg config
+
+	// log persists pending writes in durable mode; nil otherwise.
+	log *segmentLog
+
+	// mu protects everything below it, and log.
+	mu sync.Mutex
+
+	// pending holds the latest unflushed write of each key.
+	pending map[string]writeReq
+
+	// order lists pending keys in the order they were first written, so flushes are FIFO.
+	order []string
+
+	// inflight holds the writes of the batch being sent to the backing store, until it returns.
+	inflight map[string]writeReq
+
+	// spill holds writes that overflowed the queue (OverflowSpill). Opened on first use.
+	spill *spillQueue
+
+	// space is closed (and replaced) whenever the worker makes room in the queue, waking blocked writers.
+	space chan struct{}
+
+	// closed is set by Close; later writes are dropped.
+	closed bool
+
+	// kick wakes the worker up for a flush. It holds at most one signal.
+	kick chan struct{}
+
+	// stop tells the worker to flush what is left and exit.
+	stop chan struct{}
 
 	// wg is used to wait for the worker to finish
 	// during shutdown.
 	wg sync.WaitGroup
 }
 
-// NewWriteBackPolicy creates a new write-back policy.
-func NewWriteBackPolicy(store types.Loader, buffer int) *WriteBackPolicy {
-	w := &WriteBackPolicy{
-		store: store,
-		ch:    make(chan writeReq, buffer),
-	}
+// NewWriteBackPolicy creates a new write-back policy that holds up to buffer pending keys.
+func NewWriteBackPolicy(store types.Loader, buffer int, opts ...Option) *WriteBackPolicy {
+	w := newWriteBackPolicy(store, buffer, opts)
 
 	// Start one background worker
 	w.wg.Add(1)
@@ -50,45 +103,449 @@ func NewWriteBackPolicy(store types.Loader, buffer int) *WriteBackPolicy {
 	return w
 }
 
-// OnWrite is called whenever the cache writes a key.
-// We do NOT write to the backing store immediately. Instead, we push the write into a queue.
-// If the queue is full, we DROP the write. Because blocking would slow down the cache and defeat the purpose of write-back.
+/*
+NewDurableWriteBackPolicy creates a write-back policy whose queue survives crashes.
+
+Every write is appended to a segment log in dir before OnWrite returns, and fsynced
+according to sync. A write is removed from the log once it is done with: accepted by the
+backing store, replaced by a newer write of its key, or failed for good (error callback,
+dead-letter sink). On startup, writes a previous run left pending are replayed and flushed first.
+
+Values are gob-encoded: custom value types must be registered with gob.Register.
+A write that cannot be logged is failed with ErrNotDurable (error callback, dead-letter sink).
+
+Delivery is at-least-once: after a crash, some writes may reach the backing store twice.
+*/
+func NewDurableWriteBackPolicy(store types.Loader, buffer int, dir string, sync SyncPolicy, opts ...Option) (*WriteBackPolicy, error) {
+	log, pending, err := openSegmentLog(dir, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newWriteBackPolicy(store, buffer, opts)
+	w.log = log
+
+	// Replayed writes are queued even beyond the buffer: they are already durable and must not be dropped
+	for _, r := range pending {
+		w.enqueue(writeReq{context.Background(), r.rec.Key, r.rec.Value, r.rec.Delete, r.seg, r.rec.Seq})
+	}
+
+	w.wg.Add(1)
+	go w.worker()
+	w.signal()
+
+	return w, nil
+}
+
+func newWriteBackPolicy(store types.Loader, buffer int, opts []Option) *WriteBackPolicy {
+	return &WriteBackPolicy{
+		store:    store,
+		buffer:   max(buffer, 1),
+		cfg:      newConfig(opts),
+		pending:  make(map[string]writeReq),
+		inflight: make(map[string]writeReq),
+		space:    make(chan struct{}),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+/*
+OnWrite is called whenever the cache writes a key.
+We do NOT write to the backing store immediately. Instead, we queue the write.
+
+  - If the key is already queued, its value is replaced (last write wins)
+  - If the queue is full, the overflow mode decides (WithOverflow). By default we DROP the write,
+    because blocking would slow down the cache and defeat the purpose of write-back.
+
+The write is later flushed on a background context: the caller's ctx is usually
+long gone by then. Its values are kept, its cancellation is not.
+*/
 func (w *WriteBackPolicy) OnWrite(ctx context.Context, key string, value any) {
-	select {
-	case w.ch <- writeReq{ctx, key, value}:
-		// queued successfully
-	default:
+	w.queue(ctx, writeReq{ctx: context.WithoutCancel(ctx), key: key, value: value})
+}
+
+/*
+OnDelete queues a tombstone for the key, which deletes it from the backing store on flush.
+It goes through the same queue as writes, so it is ordered with them: it replaces
+a pending write of the key, and a later write replaces it.
+
+It does nothing if the backing store does not implement types.Deleter.
+*/
+func (w *WriteBackPolicy) OnDelete(ctx context.Context, key string) {
+	if _, ok := w.store.(types.Deleter); !ok {
+		return
+	}
+	w.queue(ctx, writeReq{ctx: context.WithoutCancel(ctx), key: key, delete: true})
+}
+
+// queue adds a write or tombstone to the queue, applying the overflow mode.
+func (w *WriteBackPolicy) queue(ctx context.Context, req writeReq) {
+	w.mu.Lock()
+	room, spill := w.makeRoom(ctx, req.key)
+	if !room {
 		// intentional drop under pressure. This means:
 		// - Cache stays fast
 		// - Backing store may miss some updates
+		w.mu.Unlock()
+		w.cfg.metrics.WriteDropped()
+		return
+	}
+
+	// Durable mode: the write is on disk before it is queued.
+	// A write that cannot be logged fails, rather than silently losing its crash guarantee.
+	if w.log != nil {
+		seg, seq, err := w.log.append(record{Key: req.key, Value: req.value, Delete: req.delete})
+		if err != nil {
+			w.mu.Unlock()
+			w.cfg.fail(req.ctx, req.key, req.value, fmt.Errorf("%w: %w", ErrNotDurable, err))
+			return
+		}
+		req.seg, req.seq = seg, seq
+	}
+
+	flushNow := false
+	if spill {
+		if err := w.spill.push(req); err != nil {
+			w.ack(req)
+			w.mu.Unlock()
+			w.cfg.metrics.WriteDropped()
+			return
+		}
+		w.cfg.metrics.WriteSpilled()
+	} else {
+		flushNow = w.enqueue(req)
+	}
+	depth := w.depth()
+	w.mu.Unlock()
+
+	w.cfg.metrics.WriteQueueDepth(depth)
+	if flushNow {
+		w.signal()
 	}
 }
 
 /*
-worker runs in the background and processes queued writes.
-It continuously: reads from the channel and writes data to the backing store
+Pending returns the latest write of key not yet accepted by the backing store:
+spilled, queued, or being sent. It implements PendingWrites.
+
+A write that failed for good is no longer pending: the backing store is the truth again.
+*/
+func (w *WriteBackPolicy) Pending(key string) (value any, deleted, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Newest first: spilled writes came after the queued ones, which came after the batch in flight
+	var req writeReq
+	if w.spill != nil {
+		req, ok = w.spill.latest(key)
+	}
+	if !ok {
+		req, ok = w.pending[key]
+	}
+	if !ok {
+		req, ok = w.inflight[key]
+	}
+	if !ok {
+		return nil, false, false
+	}
+	return req.value, req.delete, true
+}
+
+/*
+makeRoom applies the overflow mode when the queue is full.
+It reports whether the write can be taken, and whether it must go to the spill file.
+It must be called with w.mu held; OverflowBlock releases it while waiting.
+*/
+func (w *WriteBackPolicy) makeRoom(ctx context.Context, key string) (room, spill bool) {
+	for {
+		if w.closed {
+			return false, false
+		}
+
+		// Once spilling, every write goes to the spill file until it drains, to keep writes in order
+		if w.spilling() {
+			return true, true
+		}
+
+		if _, queued := w.pending[key]; queued || len(w.pending) < w.buffer {
+			return true, false
+		}
+
+		switch w.cfg.overflow {
+		case OverflowDropOldest:
+			w.dropOldest()
+			return true, false
+
+		case OverflowSpill:
+			if w.spill == nil {
+				q, err := openSpillQueue(w.cfg.spillDir)
+				if err != nil {
+					return false, false
+				}
+				w.spill = q
+			}
+			return true, true
+
+		case OverflowBlock:
+			space := w.space
+			w.mu.Unlock()
+			w.cfg.metrics.WriteBlocked()
+			select {
+			case <-space:
+				w.mu.Lock()
+			case <-ctx.Done():
+				w.mu.Lock()
+				return false, false
+			}
+
+		default:
+			return false, false
+		}
+	}
+}
+
+// spilling reports whether writes are waiting in the spill file. It must be called with w.mu held.
+func (w *WriteBackPolicy) spilling() bool {
+	return w.spill != nil && w.spill.len() > 0
+}
+
+// depth returns the number of writes waiting, in memory and spilled. It must be called with w.mu held.
+func (w *WriteBackPolicy) depth() int {
+	n := len(w.pending)
+	if w.spill != nil {
+		n += w.spill.len()
+	}
+	return n
+}
+
+// dropOldest drops the oldest queued write (OverflowDropOldest). It must be called with w.mu held.
+func (w *WriteBackPolicy) dropOldest() {
+	key := w.order[0]
+	w.order = w.order[1:]
+	req := w.pending[key]
+	delete(w.pending, key)
+	w.ack(req)
+	w.cfg.metrics.WriteDropped()
+}
+
+/*
+enqueue adds a write to the queue and reports whether a flush is due.
+If the key is already queued, it keeps its place in line with the newest value,
+and the write it replaces no longer needs to be delivered.
+It must be called with w.mu held.
+*/
+func (w *WriteBackPolicy) enqueue(req writeReq) bool {
+	if old, queued := w.pending[req.key]; queued {
+		w.pending[req.key] = req
+		w.ack(old)
+		return false
+	}
+	w.pending[req.key] = req
+	w.order = append(w.order, req.key)
+	return w.cfg.flushInterval <= 0 || len(w.order) >= w.cfg.batchSize
+}
+
+// ack drops a write that is done with (delivered, superseded or failed) from the log. It must be called with w.mu held.
+func (w *WriteBackPolicy) ack(req writeReq) {
+	if w.log != nil && req.seg != nil {
+		w.log.ack(req.seg, req.seq)
+	}
+}
+
+// signal wakes the worker up without blocking. One pending signal is enough.
+func (w *WriteBackPolicy) signal() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+/*
+worker runs in the background and flushes queued writes.
+It waits for a kick or the flush interval, then writes batches to the backing store.
 
 This is where eventual consistency happens.
 */
 func (w *WriteBackPolicy) worker() {
 	defer w.wg.Done()
 
-	for req := range w.ch {
-		// Ignore errors intentionally.
-		// In real systems, this might be logged or retried.
-		_ = w.store.Put(req.ctx, req.key, req.value)
+	var tick <-chan time.Time
+	if w.cfg.flushInterval > 0 {
+		ticker := time.NewTicker(w.cfg.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var syncTick <-chan time.Time
+	if w.log != nil && w.log.sync == SyncEverySecond {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		syncTick = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			w.flush()
+			return
+		case <-w.kick:
+			w.flush()
+		case <-tick:
+			w.flush()
+		case <-syncTick:
+			w.mu.Lock()
+			_ = w.log.flushToDisk()
+			w.mu.Unlock()
+		}
+	}
+}
+
+// flush writes batches until nothing is pending.
+// Writes that arrive during a slow batch are coalesced into the next one.
+func (w *WriteBackPolicy) flush() {
+	for {
+		batch := w.next()
+		if len(batch) == 0 {
+			return
+		}
+		w.write(batch)
+
+		w.mu.Lock()
+		for _, req := range batch {
+			w.ack(req)
+			delete(w.inflight, req.key)
+		}
+		if w.log != nil {
+			w.log.checkpoint()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// next takes up to one batch of pending writes, oldest first.
+func (w *WriteBackPolicy) next() []writeReq {
+	w.mu.Lock()
+	w.refill()
+
+	n := min(len(w.order), w.cfg.batchSize)
+	batch := make([]writeReq, n)
+	for i, key := range w.order[:n] {
+		batch[i] = w.pending[key]
+		w.inflight[key] = batch[i]
+		delete(w.pending, key)
+	}
+	w.order = w.order[n:]
+
+	if n > 0 {
+		// Wake up blocked writers, then let spilled writes take the freed room
+		close(w.space)
+		w.space = make(chan struct{})
+		w.refill()
+	}
+	depth := w.depth()
+	w.mu.Unlock()
+
+	if n > 0 {
+		w.cfg.metrics.WriteQueueDepth(depth)
+	}
+	return batch
+}
+
+// refill moves spilled writes back into the queue, oldest first, while there is room.
+// It must be called with w.mu held.
+func (w *WriteBackPolicy) refill() {
+	for w.spilling() && len(w.pending) < w.buffer {
+		req, err := w.spill.pop()
+		if err != nil {
+			return
+		}
+		w.enqueue(req)
+	}
+}
+
+/*
+write sends one batch to the backing store, in a single round trip if it supports PutAll.
+Tombstones are sent one Delete at a time. A batch holds each key at most once,
+so the order between its writes and tombstones does not matter.
+
+Failed writes are retried as configured, then handed to the error callback and dead-letter sink.
+Either way, every write of the batch is done with when write returns.
+*/
+func (w *WriteBackPolicy) write(batch []writeReq) {
+	bp, batched := w.store.(types.BatchPutter)
+	if batched {
+		var puts []writeReq
+		values := make(map[string]any, len(batch))
+		for _, req := range batch {
+			if !req.delete {
+				puts = append(puts, req)
+				values[req.key] = req.value
+			}
+		}
+		if len(puts) > 0 {
+			ctx := puts[0].ctx
+			if err := w.cfg.deliver(ctx, len(puts), func() error { return bp.PutAll(ctx, values) }); err != nil {
+				w.failed(puts, err)
+			}
+		}
+	}
+
+	for _, req := range batch {
+		var send func() error
+		switch {
+		case req.delete:
+			d, ok := w.store.(types.Deleter)
+			if !ok {
+				// Only a log replayed from a run with another backing store can get here
+				continue
+			}
+			send = func() error { return d.Delete(req.ctx, req.key) }
+		case !batched:
+			send = func() error { return w.store.Put(req.ctx, req.key, req.value) }
+		default:
+			continue
+		}
+
+		if err := w.cfg.deliver(req.ctx, 1, send); err != nil {
+			w.failed([]writeReq{req}, err)
+		}
+	}
+}
+
+// failed hands writes that failed for good to the error handling.
+func (w *WriteBackPolicy) failed(reqs []writeReq, err error) {
+	for _, req := range reqs {
+		w.cfg.fail(req.ctx, req.key, req.value, err)
 	}
 }
 
 /*
 Close shuts down the write-back policy gracefully.
 ------------------
-1. Close the channel (no more writes accepted)
-2. Wait for the worker to finish processing queued writes
+1. Stop the worker
+2. Wait for it to flush the writes still queued
+3. In durable mode, record the last acks and close the log
 
 Without this, pending writes could be lost when the application shuts down.
 */
 func (w *WriteBackPolicy) Close() {
-	close(w.ch)
+	// Refuse new writes and release blocked writers
+	w.mu.Lock()
+	w.closed = true
+	close(w.space)
+	w.space = make(chan struct{})
+	w.mu.Unlock()
+
+	close(w.stop)
 	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.spill != nil {
+		w.spill.close()
+	}
+	if w.log != nil {
+		_ = w.log.close()
+	}
 }
@@ -24,8 +24,30 @@ type WritePolicy interface {
 	*/
 	OnWrite(ctx context.Context, key string, value any)
 
+	/*
+		OnDelete is called whenever a key is explicitly removed from the cache.
+		Policies only forward it if the backing store implements types.Deleter.
+	*/
+	OnDelete(ctx context.Context, key string)
+
 	/*
 		Close is called when the cache is shutting down.
 	*/
 	Close()
 }
+
+/*
+PendingWrites is implemented by write policies that deliver writes later (write-back).
+
+Until a write is delivered, the backing store still has the previous value of the key,
+or still has a key that was removed. The cache asks Pending before loading a key,
+so that it never loads a value that is about to be overwritten or deleted.
+*/
+type PendingWrites interface {
+
+	/*
+		Pending returns the latest write of key that the backing store has not accepted yet.
+		deleted is true if it is a tombstone. ok is false if nothing is pending for the key.
+	*/
+	Pending(key string) (value any, deleted, ok bool)
+}
@@ -21,13 +21,16 @@ type WriteThroughPolicy struct {
 
 	// store is the backing store (DB, API, etc.) where data must be persisted immediately.
 	store types.Loader
+
+	cfg config
 }
 
 /*
 NewWriteThroughPolicy creates a new write-through policy.
+Flush and batching options only apply to write-back and are ignored here.
 */
-func NewWriteThroughPolicy(store types.Loader) *WriteThroughPolicy {
-	return &WriteThroughPolicy{store: store}
+func NewWriteThroughPolicy(store types.Loader, opts ...Option) *WriteThroughPolicy {
+	return &WriteThroughPolicy{store: store, cfg: newConfig(opts)}
 }
 
 /*
@@ -36,11 +39,32 @@ OnWrite is called whenever the cache writes a key. We immediately write the data
   - The cache write is not considered complete
     until the backing store write finishes
   - If the backing store is slow, cache writes become slow
+
+A failed write is retried as configured (WithRetry), then reported to the
+error callback and handed to the dead-letter sink.
 */
 func (w *WriteThroughPolicy) OnWrite(ctx context.Context, key string, value any) {
-	// Ignore errors for simplicity.
-	// In real systems, this might be handled or logged.
-	_ = w.store.Put(ctx, key, value)
+	err := w.cfg.deliver(ctx, 1, func() error { return w.store.Put(ctx, key, value) })
+	if err != nil {
+		w.cfg.fail(ctx, key, value, err)
+	}
+}
+
+/*
+OnDelete deletes the key from the backing store, synchronously, like OnWrite.
+It does nothing if the backing store does not implement types.Deleter.
+
+A failed delete is handled like a failed write, and reported with a nil value.
+*/
+func (w *WriteThroughPolicy) OnDelete(ctx context.Context, key string) {
+	d, ok := w.store.(types.Deleter)
+	if !ok {
+		return
+	}
+	err := w.cfg.deliver(ctx, 1, func() error { return d.Delete(ctx, key) })
+	if err != nil {
+		w.cfg.fail(ctx, key, nil, err)
+	}
 }
 
 /*